@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// matchAutoReleases returns the environments auto-released to from branch.
+func matchAutoReleases(policies []AutoReleasePolicy, branch string) []string {
+	var environments []string
+	for _, policy := range policies {
+		if branchMatches(policy.Branch, branch) {
+			environments = append(environments, policy.Environment)
+		}
+	}
+	return environments
+}
+
+// Kinds of branch patterns
+const (
+	branchPatternExact = "exact"
+	branchPatternGlob  = "glob"
+	branchPatternRegex = "regex"
+)
+
+// branchPatternKind returns how a branch pattern is matched. Patterns with
+// regular expression operators, including '.*', are regular expressions
+// matching the whole branch, so 'release/[0-9]+' does not match
+// 'hotfix/release/2026'. Patterns with only the wildcards '*', '?' and '[...]'
+// are globs like 'release/*', where '*' does not match '/'. '.' alone is not an
+// operator as it is common in branch names like 'release-1.2'. Other patterns
+// must equal the branch.
+func branchPatternKind(pattern string) string {
+	switch {
+	case strings.ContainsAny(pattern, `^$+(){}|\`) || strings.Contains(pattern, ".*"):
+		return branchPatternRegex
+	case strings.ContainsAny(pattern, "*?["):
+		return branchPatternGlob
+	default:
+		return branchPatternExact
+	}
+}
+
+// branchMatches reports whether branch matches pattern. Invalid patterns only
+// match a branch equal to them.
+func branchMatches(pattern, branch string) bool {
+	if pattern == branch {
+		return true
+	}
+	switch branchPatternKind(pattern) {
+	case branchPatternGlob:
+		matched, err := path.Match(pattern, branch)
+		return err == nil && matched
+	case branchPatternRegex:
+		matched, err := regexp.MatchString("^(?:"+pattern+")$", branch)
+		return err == nil && matched
+	default:
+		return false
+	}
+}
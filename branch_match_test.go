@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBranchMatches(t *testing.T) {
+	tt := []struct {
+		name     string
+		pattern  string
+		branch   string
+		expected bool
+	}{
+		{name: "exact", pattern: "master", branch: "master", expected: true},
+		{name: "exact mismatch", pattern: "master", branch: "main", expected: false},
+		{name: "exact is not a prefix", pattern: "release", branch: "release/2026-10", expected: false},
+		{name: "exact with dot", pattern: "release-1.2", branch: "release-1x2", expected: false},
+		{name: "glob", pattern: "release/*", branch: "release/2026-10", expected: true},
+		{name: "glob is not a regex prefix", pattern: "release/*", branch: "prerelease", expected: false},
+		{name: "glob requires the separator", pattern: "release/*", branch: "releases", expected: false},
+		{name: "glob does not match within branch", pattern: "release/*", branch: "old-release-notes", expected: false},
+		{name: "glob star does not match separator", pattern: "release/*", branch: "release/2026/10", expected: false},
+		{name: "glob character class", pattern: "release/202[56]-??", branch: "release/2026-10", expected: true},
+		{name: "invalid glob equal to branch", pattern: "release/[", branch: "release/[", expected: true},
+		{name: "invalid glob", pattern: "release/[", branch: "release/2026-10", expected: false},
+		{name: "regex", pattern: "release/.*", branch: "release/2026/10", expected: true},
+		{name: "regex matches the whole branch", pattern: "release/[0-9]+", branch: "hotfix/old-release/2026", expected: false},
+		{name: "regex mismatch", pattern: "release/[0-9]+", branch: "release/next", expected: false},
+		{name: "anchored regex", pattern: "^release/.*$", branch: "release/2026/10", expected: true},
+		{name: "anchored regex mismatch", pattern: "^release/.*$", branch: "hotfix/2026-10", expected: false},
+		{name: "alternation", pattern: "master|main", branch: "main", expected: true},
+		{name: "invalid regex", pattern: "^release/(", branch: "release/2026-10", expected: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, branchMatches(tc.pattern, tc.branch))
+		})
+	}
+}
+
+func TestBranchPatternKind(t *testing.T) {
+	assert.Equal(t, branchPatternExact, branchPatternKind("master"))
+	assert.Equal(t, branchPatternExact, branchPatternKind("release-1.2"))
+	assert.Equal(t, branchPatternGlob, branchPatternKind("release/*"))
+	assert.Equal(t, branchPatternGlob, branchPatternKind("release/202?-[01][0-9]"))
+	assert.Equal(t, branchPatternRegex, branchPatternKind("release/.*"))
+	assert.Equal(t, branchPatternRegex, branchPatternKind("^release/.*$"))
+	assert.Equal(t, branchPatternRegex, branchPatternKind("release/[0-9]+$"))
+}
+
+func TestMatchAutoReleases(t *testing.T) {
+	policies := []AutoReleasePolicy{
+		{Branch: "master", Environment: "dev"},
+		{Branch: "release/*", Environment: "staging"},
+		{Branch: "^release/[0-9]{4}-[0-9]{2}$", Environment: "prod"},
+	}
+
+	tt := []struct {
+		name     string
+		branch   string
+		expected []string
+	}{
+		{name: "exact", branch: "master", expected: []string{"dev"}},
+		{name: "glob and regex", branch: "release/2026-10", expected: []string{"staging", "prod"}},
+		{name: "glob only", branch: "release/next", expected: []string{"staging"}},
+		{name: "no match", branch: "prerelease", expected: nil},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, matchAutoReleases(policies, tc.branch))
+		})
+	}
+}
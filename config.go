@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// configEnvPrefix prefixes the environment variable equivalent of every flag.
+// The flag 'github-private-key' is read from RELEASE_MANAGER_BOT_GITHUB_PRIVATE_KEY.
+const configEnvPrefix = "RELEASE_MANAGER_BOT_"
+
+// configFlag is the flag with the path of the configuration file.
+const configFlag = "config"
+
+// secretFlags hold secrets. Each of them has a '-file' variant reading the
+// secret from a file so it is not visible in process listings.
+var secretFlags = []string{
+	"github-private-key",
+	"github-webhook-secret",
+	"release-manager-auth-token",
+	"release-manager-webhook-secret",
+	"admin-auth-token",
+}
+
+// Subcommands
+const (
+	SubcommandServe          = "serve"
+	SubcommandValidateConfig = "validate-config"
+	SubcommandReplay         = "replay"
+)
+
+// parseSubcommand splits the subcommand from the flags in args. Without a
+// subcommand the bot serves webhooks.
+func parseSubcommand(args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return SubcommandServe, args, nil
+	}
+	switch args[0] {
+	case SubcommandServe, SubcommandValidateConfig, SubcommandReplay:
+		return args[0], args[1:], nil
+	default:
+		return "", nil, errors.Errorf("unknown subcommand '%s'", args[0])
+	}
+}
+
+// addConfigFlags adds the configuration file flag and the '-file' variants of
+// secretFlags to flags.
+func addConfigFlags(flags *pflag.FlagSet) {
+	flags.String(configFlag, "", "Path of a YAML or JSON file with flag names as keys. Flags are set from, in order of precedence: the command line, "+configEnvPrefix+"<FLAG_NAME> environment variables, the configuration file and flag defaults")
+	for _, name := range secretFlags {
+		flags.String(name+"-file", "", fmt.Sprintf("Path of a file containing the value of --%s", name))
+	}
+}
+
+// configEnvName returns the environment variable equivalent of a flag.
+func configEnvName(flag string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// loadConfig parses args into flags and sets the flags not set on the command
+// line from environment variables and then the configuration file. Secrets of
+// '-file' flags are read last. Setting a secret and its '-file' variant is an
+// error regardless of where they are set.
+func loadConfig(flags *pflag.FlagSet, args []string, lookupEnv func(string) (string, bool), readFile func(string) ([]byte, error)) error {
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	commandLine := make(map[string]bool)
+	flags.Visit(func(f *pflag.Flag) {
+		commandLine[f.Name] = true
+	})
+
+	if !commandLine[configFlag] {
+		if value, ok := lookupEnv(configEnvName(configFlag)); ok {
+			err := flags.Set(configFlag, value)
+			if err != nil {
+				return errors.Wrapf(err, "setting '%s' from environment variable %s", configFlag, configEnvName(configFlag))
+			}
+		}
+	}
+	fileValues := make(map[string]string)
+	configPath, err := flags.GetString(configFlag)
+	if err != nil {
+		return err
+	}
+	if configPath != "" {
+		content, err := readFile(configPath)
+		if err != nil {
+			return errors.Wrap(err, "reading configuration file")
+		}
+		fileValues, err = parseConfigFile(flags, content)
+		if err != nil {
+			return errors.Wrapf(err, "parsing configuration file '%s'", configPath)
+		}
+	}
+
+	var setErr error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if setErr != nil || commandLine[f.Name] || f.Name == configFlag {
+			return
+		}
+		source := "environment variable " + configEnvName(f.Name)
+		value, ok := lookupEnv(configEnvName(f.Name))
+		if !ok {
+			source = "configuration file"
+			value, ok = fileValues[f.Name]
+		}
+		if !ok {
+			return
+		}
+		err := flags.Set(f.Name, value)
+		if err != nil {
+			setErr = errors.Wrapf(err, "setting '%s' from %s", f.Name, source)
+		}
+	})
+	if setErr != nil {
+		return setErr
+	}
+
+	for _, name := range secretFlags {
+		fileFlag := flags.Lookup(name + "-file")
+		if fileFlag == nil || fileFlag.Value.String() == "" {
+			continue
+		}
+		if flags.Changed(name) {
+			return errors.Errorf("both '%s' and '%s-file' are set", name, name)
+		}
+		content, err := readFile(fileFlag.Value.String())
+		if err != nil {
+			return errors.Wrapf(err, "reading '%s-file'", name)
+		}
+		err = flags.Set(name, strings.TrimRight(string(content), "\r\n"))
+		if err != nil {
+			return errors.Wrapf(err, "setting '%s' from '%s-file'", name, name)
+		}
+	}
+	return nil
+}
+
+// parseConfigFile returns the flag values of a configuration file as they are
+// written on the command line. Lists are comma separated and maps are written
+// as 'key=value' pairs.
+func parseConfigFile(flags *pflag.FlagSet, content []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	err := yaml.Unmarshal(content, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if name == configFlag || flags.Lookup(name) == nil {
+			return nil, errors.Errorf("unknown option '%s'", name)
+		}
+		switch v := value.(type) {
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ",")
+		case map[string]interface{}:
+			pairs := make([]string, 0, len(v))
+			for key, item := range v {
+				pairs = append(pairs, fmt.Sprintf("%s=%v", key, item))
+			}
+			sort.Strings(pairs)
+			values[name] = strings.Join(pairs, ",")
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
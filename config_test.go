@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tt := []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		files         map[string]string
+		expected      map[string]string
+		expectedError string
+	}{
+		{
+			name: "defaults",
+			expected: map[string]string{
+				"release-manager-url":  "http://localhost:8080",
+				"ignored-repositories": "[]",
+			},
+		},
+		{
+			name: "configuration file overrides defaults",
+			args: []string{"--config", "config.yaml"},
+			files: map[string]string{
+				"config.yaml": "release-manager-url: http://release-manager\nhttp-port: 9090\nignored-repositories: [repo1, repo2]\nmap-repo-to-service:\n  repo1: service1\n  repo2: service2\n",
+			},
+			expected: map[string]string{
+				"release-manager-url":  "http://release-manager",
+				"http-port":            "9090",
+				"ignored-repositories": "[repo1,repo2]",
+				"map-repo-to-service":  "[repo1=service1,repo2=service2]",
+			},
+		},
+		{
+			name: "JSON configuration file",
+			args: []string{"--config", "config.json"},
+			files: map[string]string{
+				"config.json": `{"release-manager-url": "http://release-manager", "explain-command": true}`,
+			},
+			expected: map[string]string{
+				"release-manager-url": "http://release-manager",
+				"explain-command":     "true",
+			},
+		},
+		{
+			name: "environment overrides configuration file",
+			env: map[string]string{
+				"RELEASE_MANAGER_BOT_CONFIG":              "config.yaml",
+				"RELEASE_MANAGER_BOT_RELEASE_MANAGER_URL": "http://env",
+			},
+			files: map[string]string{
+				"config.yaml": "release-manager-url: http://file\nhttp-port: 9090\n",
+			},
+			expected: map[string]string{
+				"release-manager-url": "http://env",
+				"http-port":           "9090",
+			},
+		},
+		{
+			name: "command line overrides environment",
+			args: []string{"--config", "config.yaml", "--release-manager-url", "http://cli", "--ignored-repositories", "repo3"},
+			env: map[string]string{
+				"RELEASE_MANAGER_BOT_RELEASE_MANAGER_URL":  "http://env",
+				"RELEASE_MANAGER_BOT_IGNORED_REPOSITORIES": "repo2",
+			},
+			files: map[string]string{
+				"config.yaml": "release-manager-url: http://file\nignored-repositories: [repo1]\n",
+			},
+			expected: map[string]string{
+				"release-manager-url":  "http://cli",
+				"ignored-repositories": "[repo3]",
+			},
+		},
+		{
+			name:  "secret from file",
+			args:  []string{"--release-manager-auth-token-file", "/run/secrets/token"},
+			files: map[string]string{"/run/secrets/token": "secret\n"},
+			expected: map[string]string{
+				"release-manager-auth-token": "secret",
+			},
+		},
+		{
+			name:  "secret file from environment",
+			env:   map[string]string{"RELEASE_MANAGER_BOT_RELEASE_MANAGER_AUTH_TOKEN_FILE": "/run/secrets/token"},
+			files: map[string]string{"/run/secrets/token": "secret"},
+			expected: map[string]string{
+				"release-manager-auth-token": "secret",
+			},
+		},
+		{
+			name:          "secret and secret file",
+			args:          []string{"--release-manager-auth-token-file", "/run/secrets/token"},
+			env:           map[string]string{"RELEASE_MANAGER_BOT_RELEASE_MANAGER_AUTH_TOKEN": "secret"},
+			files:         map[string]string{"/run/secrets/token": "secret"},
+			expectedError: "both 'release-manager-auth-token' and 'release-manager-auth-token-file' are set",
+		},
+		{
+			name:          "unknown option in configuration file",
+			args:          []string{"--config", "config.yaml"},
+			files:         map[string]string{"config.yaml": "release-manager-uri: http://file\n"},
+			expectedError: "parsing configuration file 'config.yaml': unknown option 'release-manager-uri'",
+		},
+		{
+			name:          "invalid environment variable",
+			env:           map[string]string{"RELEASE_MANAGER_BOT_HTTP_PORT": "http"},
+			expectedError: "setting 'http-port' from environment variable RELEASE_MANAGER_BOT_HTTP_PORT",
+		},
+		{
+			name:          "missing configuration file",
+			args:          []string{"--config", "config.yaml"},
+			expectedError: "reading configuration file",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			flags := pflag.NewFlagSet("release-manager-bot", pflag.ContinueOnError)
+			flags.String("release-manager-url", "http://localhost:8080", "")
+			flags.String("release-manager-auth-token", "", "")
+			flags.Int("http-port", 8080, "")
+			flags.Bool("explain-command", false, "")
+			flags.StringSlice("ignored-repositories", []string{}, "")
+			flags.StringToString("map-repo-to-service", map[string]string{}, "")
+			addConfigFlags(flags)
+
+			lookupEnv := func(name string) (string, bool) {
+				value, ok := tc.env[name]
+				return value, ok
+			}
+			readFile := func(path string) ([]byte, error) {
+				content, ok := tc.files[path]
+				if !ok {
+					return nil, os.ErrNotExist
+				}
+				return []byte(content), nil
+			}
+
+			err := loadConfig(flags, tc.args, lookupEnv, readFile)
+
+			if tc.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			for name, value := range tc.expected {
+				actual := flags.Lookup(name).Value.String()
+				if flags.Lookup(name).Value.Type() == "stringToString" {
+					// Maps are formatted in random order
+					assert.ElementsMatch(t, strings.Split(strings.Trim(value, "[]"), ","), strings.Split(strings.Trim(actual, "[]"), ","), name)
+					continue
+				}
+				assert.Equal(t, value, actual, name)
+			}
+		})
+	}
+}
+
+func TestParseSubcommand(t *testing.T) {
+	subcommand, args, err := parseSubcommand([]string{"--http-port", "8080"})
+	require.NoError(t, err)
+	assert.Equal(t, SubcommandServe, subcommand)
+	assert.Equal(t, []string{"--http-port", "8080"}, args)
+
+	subcommand, args, err = parseSubcommand([]string{"validate-config", "--config", "config.yaml"})
+	require.NoError(t, err)
+	assert.Equal(t, SubcommandValidateConfig, subcommand)
+	assert.Equal(t, []string{"--config", "config.yaml"}, args)
+
+	_, _, err = parseSubcommand([]string{"deploy"})
+	assert.EqualError(t, err, "unknown subcommand 'deploy'")
+}
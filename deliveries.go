@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Delivery outcomes
+const (
+	DeliveryOutcomeCompleted = "completed"
+	DeliveryOutcomeFiltered  = "filtered"
+	DeliveryOutcomeFailed    = "failed"
+)
+
+// Delivery describes how a webhook delivery was handled.
+type Delivery struct {
+	ID                  string        `json:"id"`
+	Event               string        `json:"event"`
+	Action              string        `json:"action,omitempty"`
+	Repository          string        `json:"repository,omitempty"`
+	PullRequest         int           `json:"pullRequest,omitempty"`
+	Service             string        `json:"service,omitempty"`
+	ReceivedAt          time.Time     `json:"receivedAt"`
+	Duration            time.Duration `json:"duration"`
+	Filters             []string      `json:"filters,omitempty"`
+	PolicyFilters       []string      `json:"policyFilters,omitempty"`
+	ReleaseManagerCalls []HTTPCall    `json:"releaseManagerCalls,omitempty"`
+	GithubCalls         []HTTPCall    `json:"githubCalls,omitempty"`
+	Outcome             string        `json:"outcome"`
+	Error               string        `json:"error,omitempty"`
+}
+
+// HTTPCall is an outbound HTTP request made while handling a delivery.
+type HTTPCall struct {
+	Method     string        `json:"method"`
+	URL        string        `json:"url"`
+	StatusCode int           `json:"statusCode,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	Error      string        `json:"error,omitempty"`
+	DryRun     bool          `json:"dryRun,omitempty"`
+}
+
+type deliveryContextKey struct{}
+
+// deliveryRecord collects the details of a delivery while it is handled.
+type deliveryRecord struct {
+	mu       sync.Mutex
+	delivery Delivery
+}
+
+func withDeliveryRecord(ctx context.Context, record *deliveryRecord) context.Context {
+	return context.WithValue(ctx, deliveryContextKey{}, record)
+}
+
+// deliveryFromContext returns the record of the delivery handled with ctx. It
+// is nil outside of webhook deliveries, eg. during reconciliation, where
+// recording is a no-op.
+func deliveryFromContext(ctx context.Context) *deliveryRecord {
+	record, _ := ctx.Value(deliveryContextKey{}).(*deliveryRecord)
+	return record
+}
+
+// checkFilter runs check of filter in a span and records the filter on the
+// delivery handled with ctx if it triggered, i.e. stopped the handling.
+func checkFilter(ctx context.Context, filter string, check func() bool) bool {
+	if !traceFilterCheck(ctx, filter, check) {
+		return false
+	}
+	deliveryFilter(ctx, filter, false)
+	return true
+}
+
+// checkPolicyFilter is checkFilter for the handling of policy files. Policy
+// files are handled independently of auto-release comments so these filters
+// are recorded separately and do not make a delivery filtered.
+func checkPolicyFilter(ctx context.Context, filter string, check func() bool) bool {
+	if !traceFilterCheck(ctx, filter, check) {
+		return false
+	}
+	deliveryFilter(ctx, filter, true)
+	return true
+}
+
+func deliveryFilter(ctx context.Context, filter string, policy bool) {
+	record := deliveryFromContext(ctx)
+	if record == nil {
+		return
+	}
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	if policy {
+		record.delivery.PolicyFilters = append(record.delivery.PolicyFilters, filter)
+		return
+	}
+	record.delivery.Filters = append(record.delivery.Filters, filter)
+}
+
+// deliveryService records the service resolved for a delivery.
+func deliveryService(ctx context.Context, service string) {
+	record := deliveryFromContext(ctx)
+	if record == nil {
+		return
+	}
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	record.delivery.Service = service
+}
+
+func (record *deliveryRecord) call(destination string, call HTTPCall) {
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	switch destination {
+	case "github":
+		record.delivery.GithubCalls = append(record.delivery.GithubCalls, call)
+	default:
+		record.delivery.ReleaseManagerCalls = append(record.delivery.ReleaseManagerCalls, call)
+	}
+}
+
+// deliveryCallsMiddleware records outbound requests to destination on the
+// delivery they are made for.
+func deliveryCallsMiddleware(destination string) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			record := deliveryFromContext(r.Context())
+			if record == nil {
+				return next.RoundTrip(r)
+			}
+
+			start := time.Now()
+			res, err := next.RoundTrip(r)
+
+			call := HTTPCall{
+				Method:   r.Method,
+				URL:      r.URL.Redacted(),
+				Duration: time.Since(start),
+			}
+			if err != nil {
+				call.Error = err.Error()
+			} else if res != nil {
+				call.StatusCode = res.StatusCode
+				call.DryRun = res.Header.Get(dryRunHeader) != ""
+			}
+			record.call(destination, call)
+
+			return res, err
+		})
+	}
+}
+
+// DeliveryLog keeps the latest deliveries in a ring buffer. If path is set
+// the buffer is restored from it on startup and persisted to it as JSON by Run
+// and Flush, off the path of handling webhooks.
+type DeliveryLog struct {
+	mu         sync.RWMutex
+	deliveries []Delivery
+	next       int
+	full       bool
+	dirty      bool
+	path       string
+
+	// persistMu serializes writes of the file
+	persistMu sync.Mutex
+}
+
+func NewDeliveryLog(size int, path string) (*DeliveryLog, error) {
+	if size <= 0 {
+		return nil, errors.Errorf("size must be positive, got %d", size)
+	}
+	l := &DeliveryLog{
+		deliveries: make([]Delivery, size),
+		path:       path,
+	}
+	if path == "" {
+		return l, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading delivery log")
+	}
+	var deliveries []Delivery
+	err = json.Unmarshal(content, &deliveries)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing delivery log")
+	}
+	// Oldest first so the latest deliveries are kept if the size was reduced
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		l.insert(deliveries[i])
+	}
+	return l, nil
+}
+
+func (l *DeliveryLog) insert(delivery Delivery) {
+	l.deliveries[l.next] = delivery
+	l.next = (l.next + 1) % len(l.deliveries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Add stores a delivery, overwriting the oldest one if the log is full.
+func (l *DeliveryLog) Add(delivery Delivery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.insert(delivery)
+	l.dirty = true
+}
+
+// Run persists the deliveries every interval if they changed until ctx is
+// cancelled. Flush persists the deliveries added after that.
+func (l *DeliveryLog) Run(ctx context.Context, interval time.Duration, logger zerolog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := l.Flush()
+		if err != nil {
+			logger.Error().Msgf("Failed to persist delivery log: %v", err)
+		}
+	}
+}
+
+// Flush persists the deliveries if they changed since they were last
+// persisted. The deliveries are written to a temporary file and renamed over
+// path so a crash never leaves a partial log behind.
+func (l *DeliveryLog) Flush() error {
+	if l.path == "" {
+		return nil
+	}
+	l.persistMu.Lock()
+	defer l.persistMu.Unlock()
+
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	content, err := json.Marshal(l.latest("", 0, 0))
+	l.dirty = false
+	l.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "encoding delivery log")
+	}
+
+	err = l.write(content)
+	if err != nil {
+		l.mu.Lock()
+		l.dirty = true
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (l *DeliveryLog) write(content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary delivery log")
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "writing temporary delivery log")
+	}
+	err = os.Rename(tmp.Name(), l.path)
+	if err != nil {
+		return errors.Wrap(err, "replacing delivery log")
+	}
+	return nil
+}
+
+// List returns the latest deliveries first, optionally only those of
+// repository and pull request. At most limit deliveries are returned unless
+// limit is 0.
+func (l *DeliveryLog) List(repository string, pullRequest, limit int) []Delivery {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.latest(repository, pullRequest, limit)
+}
+
+func (l *DeliveryLog) latest(repository string, pullRequest, limit int) []Delivery {
+	count := l.next
+	if l.full {
+		count = len(l.deliveries)
+	}
+	deliveries := []Delivery{}
+	for i := 1; i <= count; i++ {
+		delivery := l.deliveries[(l.next-i+len(l.deliveries))%len(l.deliveries)]
+		if repository != "" && delivery.Repository != repository {
+			continue
+		}
+		if pullRequest != 0 && delivery.PullRequest != pullRequest {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+		if limit > 0 && len(deliveries) == limit {
+			break
+		}
+	}
+	return deliveries
+}
+
+// ServeHTTP lists the latest deliveries. The query parameters 'repository'
+// (owner/name), 'pullRequest' and 'limit' narrow down the result.
+func (l *DeliveryLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var pullRequest, limit int
+	var err error
+	if value := query.Get("pullRequest"); value != "" {
+		pullRequest, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "invalid pullRequest", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(l.List(query.Get("repository"), pullRequest, limit))
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("Failed to encode deliveries: %v", err)
+	}
+}
+
+// deliveryPayload is the part of webhook payloads identifying what a delivery
+// is about.
+type deliveryPayload struct {
+	Action     string `json:"action"`
+	Number     int    `json:"number"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Issue struct {
+		Number int `json:"number"`
+	} `json:"issue"`
+}
+
+// recordingEventHandler records the deliveries handled by an event handler in
+// a DeliveryLog.
+type recordingEventHandler struct {
+	githubapp.EventHandler
+	log *DeliveryLog
+}
+
+func (h *recordingEventHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	record := &deliveryRecord{
+		delivery: Delivery{
+			ID:         deliveryID,
+			Event:      eventType,
+			ReceivedAt: time.Now(),
+		},
+	}
+	var p deliveryPayload
+	if json.Unmarshal(payload, &p) == nil {
+		record.delivery.Action = p.Action
+		record.delivery.Repository = p.Repository.FullName
+		record.delivery.PullRequest = p.Number
+		if record.delivery.PullRequest == 0 {
+			record.delivery.PullRequest = p.Issue.Number
+		}
+	}
+
+	err := h.EventHandler.Handle(withDeliveryRecord(ctx, record), eventType, deliveryID, payload)
+
+	record.mu.Lock()
+	delivery := record.delivery
+	record.mu.Unlock()
+	delivery.Duration = time.Since(delivery.ReceivedAt)
+	switch {
+	case err != nil:
+		delivery.Outcome = DeliveryOutcomeFailed
+		delivery.Error = err.Error()
+	case len(delivery.Filters) > 0:
+		delivery.Outcome = DeliveryOutcomeFiltered
+	default:
+		delivery.Outcome = DeliveryOutcomeCompleted
+	}
+	h.log.Add(delivery)
+	return err
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliveryLog_List(t *testing.T) {
+	log, err := NewDeliveryLog(3, "")
+	require.NoError(t, err)
+
+	for _, delivery := range []Delivery{
+		{ID: "1", Repository: "lunarway/a", PullRequest: 1},
+		{ID: "2", Repository: "lunarway/b", PullRequest: 1},
+		{ID: "3", Repository: "lunarway/a", PullRequest: 2},
+		{ID: "4", Repository: "lunarway/a", PullRequest: 1},
+	} {
+		log.Add(delivery)
+	}
+
+	tt := []struct {
+		name        string
+		repository  string
+		pullRequest int
+		limit       int
+		expectedIDs []string
+	}{
+		{name: "oldest overwritten", expectedIDs: []string{"4", "3", "2"}},
+		{name: "limit", limit: 2, expectedIDs: []string{"4", "3"}},
+		{name: "repository", repository: "lunarway/a", expectedIDs: []string{"4", "3"}},
+		{name: "pull request", repository: "lunarway/a", pullRequest: 1, expectedIDs: []string{"4"}},
+		{name: "no match", repository: "lunarway/c", expectedIDs: []string{}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ids := []string{}
+			for _, delivery := range log.List(tc.repository, tc.pullRequest, tc.limit) {
+				ids = append(ids, delivery.ID)
+			}
+			assert.Equal(t, tc.expectedIDs, ids)
+		})
+	}
+}
+
+func TestDeliveryLog_persisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deliveries.json")
+	log, err := NewDeliveryLog(3, path)
+	require.NoError(t, err)
+	for _, id := range []string{"1", "2", "3"} {
+		log.Add(Delivery{ID: id})
+	}
+	assert.NoFileExists(t, path, "not persisted while handling deliveries")
+	require.NoError(t, log.Flush())
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, log.Flush())
+	assert.NoFileExists(t, path, "unchanged deliveries are not persisted again")
+	log.Add(Delivery{ID: "4"})
+	require.NoError(t, log.Flush())
+
+	// Restored into a smaller log
+	restored, err := NewDeliveryLog(2, path)
+	require.NoError(t, err)
+
+	deliveries := restored.List("", 0, 0)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, "4", deliveries[0].ID)
+	assert.Equal(t, "3", deliveries[1].ID)
+}
+
+type fakeEventHandler func(ctx context.Context, eventType, deliveryID string, payload []byte) error
+
+func (fakeEventHandler) Handles() []string {
+	return []string{"pull_request"}
+}
+
+func (f fakeEventHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	return f(ctx, eventType, deliveryID, payload)
+}
+
+func TestRecordingEventHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	releaseManager := &http.Client{Transport: deliveryCallsMiddleware("release-manager")(http.DefaultTransport)}
+	payload := []byte(`{"action": "opened", "number": 42, "repository": {"full_name": "lunarway/lunar-way-product-service"}}`)
+
+	tt := []struct {
+		name            string
+		handle          fakeEventHandler
+		expectedOutcome string
+		expectedError   string
+		expectedFilters []string
+	}{
+		{
+			name: "completed",
+			handle: func(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+				return nil
+			},
+			expectedOutcome: DeliveryOutcomeCompleted,
+		},
+		{
+			name: "filtered",
+			handle: func(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+				checkFilter(ctx, "UnmanagedService", func() bool { return true })
+				return nil
+			},
+			expectedOutcome: DeliveryOutcomeFiltered,
+			expectedFilters: []string{"UnmanagedService"},
+		},
+		{
+			name: "failed",
+			handle: func(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+				return errors.New("release-manager unavailable")
+			},
+			expectedOutcome: DeliveryOutcomeFailed,
+			expectedError:   "release-manager unavailable",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			log, err := NewDeliveryLog(1, "")
+			require.NoError(t, err)
+			handler := &recordingEventHandler{
+				EventHandler: fakeEventHandler(func(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+					deliveryService(ctx, "product")
+					req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/policies?service=product", nil)
+					require.NoError(t, err)
+					res, err := releaseManager.Do(req)
+					require.NoError(t, err)
+					res.Body.Close()
+					return tc.handle(ctx, eventType, deliveryID, payload)
+				}),
+				log: log,
+			}
+
+			err = handler.Handle(context.Background(), "pull_request", "delivery-id", payload)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			deliveries := log.List("", 0, 0)
+			require.Len(t, deliveries, 1)
+			delivery := deliveries[0]
+			assert.Equal(t, "delivery-id", delivery.ID)
+			assert.Equal(t, "pull_request", delivery.Event)
+			assert.Equal(t, "opened", delivery.Action)
+			assert.Equal(t, "lunarway/lunar-way-product-service", delivery.Repository)
+			assert.Equal(t, 42, delivery.PullRequest)
+			assert.Equal(t, "product", delivery.Service)
+			assert.Equal(t, tc.expectedOutcome, delivery.Outcome)
+			assert.Equal(t, tc.expectedError, delivery.Error)
+			assert.Equal(t, tc.expectedFilters, delivery.Filters)
+			require.Len(t, delivery.ReleaseManagerCalls, 1)
+			assert.Equal(t, http.StatusNotFound, delivery.ReleaseManagerCalls[0].StatusCode)
+			assert.Empty(t, delivery.GithubCalls)
+		})
+	}
+}
+
+func TestRecordingEventHandler_policyFileFiltered(t *testing.T) {
+	handler, fakeGithub, _ := newFakeHandler(t, fstest.MapFS{}, exampleReleaseManagerFixtures)
+	handler.policyFilePath = ".release-manager/policies.yaml"
+	log, err := NewDeliveryLog(1, "")
+	require.NoError(t, err)
+	recording := &recordingEventHandler{EventHandler: handler, log: log}
+	payload, err := replayOptions{
+		Synthetic:      SyntheticEventOpened,
+		Repository:     "lunarway/example",
+		PullRequest:    1,
+		Base:           "master",
+		Head:           "feature",
+		HeadSHA:        "abc",
+		InstallationID: 1,
+	}.syntheticPullRequestEvent()
+	require.NoError(t, err)
+
+	err = recording.Handle(zerolog.Nop().WithContext(context.Background()), "pull_request", "delivery-id", payload)
+
+	require.NoError(t, err)
+	require.Len(t, fakeGithub.Comments("lunarway", "example", 1), 1, "comments")
+	deliveries := log.List("", 0, 0)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, DeliveryOutcomeCompleted, deliveries[0].Outcome)
+	assert.Empty(t, deliveries[0].Filters)
+	assert.Equal(t, []string{"PolicyFileNotChanged"}, deliveries[0].PolicyFilters)
+}
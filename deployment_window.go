@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// DeploymentWindowConfig is the configuration of when auto-releases to an
+// environment are allowed. Ex.
+//
+//	prod:
+//	  timeZone: Europe/Copenhagen
+//	  weekdays: [Monday, Tuesday, Wednesday, Thursday, Friday]
+//	  start: "08:00"
+//	  end: "16:00"
+//	  holidays: ["2026-12-24", "2026-12-31"]
+type DeploymentWindowConfig struct {
+	TimeZone string   `yaml:"timeZone"`
+	Weekdays []string `yaml:"weekdays"`
+	Start    string   `yaml:"start"`
+	End      string   `yaml:"end"`
+	Holidays []string `yaml:"holidays"`
+}
+
+type deploymentWindow struct {
+	location *time.Location
+	weekdays map[time.Weekday]bool
+	start    time.Duration
+	end      time.Duration
+	holidays map[string]bool
+}
+
+// DeploymentWindows maps environment names to their deployment window.
+// Environments without a window allow auto-releases at any time.
+type DeploymentWindows map[string]deploymentWindow
+
+func loadDeploymentWindows(path string) (DeploymentWindows, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading deployment windows file '%s'", path)
+	}
+	var configs map[string]DeploymentWindowConfig
+	err = yaml.Unmarshal(content, &configs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing deployment windows file '%s'", path)
+	}
+	return parseDeploymentWindows(configs)
+}
+
+func parseDeploymentWindows(configs map[string]DeploymentWindowConfig) (DeploymentWindows, error) {
+	windows := make(DeploymentWindows)
+	for environment, config := range configs {
+		window, err := parseDeploymentWindow(config)
+		if err != nil {
+			return nil, errors.Wrapf(err, "deployment window of environment '%s'", environment)
+		}
+		windows[environment] = window
+	}
+	return windows, nil
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+func parseDeploymentWindow(config DeploymentWindowConfig) (deploymentWindow, error) {
+	location, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return deploymentWindow{}, errors.Wrapf(err, "loading time zone '%s'", config.TimeZone)
+	}
+
+	window := deploymentWindow{
+		location: location,
+		weekdays: make(map[time.Weekday]bool),
+		holidays: make(map[string]bool),
+		end:      24 * time.Hour,
+	}
+
+	for _, name := range config.Weekdays {
+		weekday, ok := weekdayNames[strings.ToLower(name)]
+		if !ok {
+			return deploymentWindow{}, errors.Errorf("unknown weekday '%s'", name)
+		}
+		window.weekdays[weekday] = true
+	}
+	if len(config.Weekdays) == 0 {
+		for _, weekday := range weekdayNames {
+			window.weekdays[weekday] = true
+		}
+	}
+
+	if config.Start != "" {
+		window.start, err = parseTimeOfDay(config.Start)
+		if err != nil {
+			return deploymentWindow{}, errors.Wrap(err, "parsing start")
+		}
+	}
+	if config.End != "" {
+		window.end, err = parseTimeOfDay(config.End)
+		if err != nil {
+			return deploymentWindow{}, errors.Wrap(err, "parsing end")
+		}
+	}
+	if window.start >= window.end {
+		return deploymentWindow{}, errors.Errorf("start '%s' must be before end '%s'", config.Start, config.End)
+	}
+
+	for _, holiday := range config.Holidays {
+		_, err := time.Parse(time.DateOnly, holiday)
+		if err != nil {
+			return deploymentWindow{}, errors.Wrapf(err, "parsing holiday '%s'", holiday)
+		}
+		window.holidays[holiday] = true
+	}
+
+	return window, nil
+}
+
+// parseTimeOfDay parses a time of day in the format 15:04 into the duration
+// since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w deploymentWindow) allows(t time.Time) bool {
+	local := t.In(w.location)
+	if !w.weekdays[local.Weekday()] {
+		return false
+	}
+	if w.holidays[local.Format(time.DateOnly)] {
+		return false
+	}
+	sinceMidnight := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	return sinceMidnight >= w.start && sinceMidnight < w.end
+}
+
+// outside returns the environments that do not allow auto-releases at time t.
+func (windows DeploymentWindows) outside(environments []string, t time.Time) []string {
+	var outside []string
+	for _, environment := range environments {
+		window, ok := windows[environment]
+		if ok && !window.allows(t) {
+			outside = append(outside, environment)
+		}
+	}
+	return outside
+}
+
+// deploymentWindowStatus returns a commit status that fails while a merge
+// would auto-release outside the deployment window of any environment.
+func deploymentWindowStatus(statusContext string, autoReleaseEnvironments []string, outside []string) github.RepoStatus {
+	status := github.RepoStatus{
+		Context: github.Ptr(statusContext),
+	}
+	switch {
+	case len(autoReleaseEnvironments) == 0:
+		status.State = github.Ptr("success")
+		status.Description = github.Ptr("No auto-release")
+	case len(outside) == 0:
+		status.State = github.Ptr("success")
+		status.Description = github.Ptr("Within deployment windows")
+	default:
+		status.State = github.Ptr("failure")
+		status.Description = github.Ptr(truncate(fmt.Sprintf("Outside deployment window of %s", strings.Join(outside, ", ")), maxStatusDescriptionLength))
+	}
+	return status
+}
+
+func deploymentWindowWarning(outside []string) string {
+	if len(outside) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Merging now auto-releases outside the deployment window of %s", strings.Join(outside, ", "))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeploymentWindowsOutside(t *testing.T) {
+	windows, err := parseDeploymentWindows(map[string]DeploymentWindowConfig{
+		"prod": {
+			TimeZone: "Europe/Copenhagen",
+			Weekdays: []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday"},
+			Start:    "08:00",
+			End:      "16:00",
+			Holidays: []string{"2026-12-24"},
+		},
+	})
+	require.NoError(t, err)
+
+	tt := []struct {
+		name     string
+		time     string
+		expected []string
+	}{
+		{
+			name:     "within window",
+			time:     "2026-10-19T10:00:00+02:00",
+			expected: nil,
+		},
+		{
+			name:     "within window in other time zone",
+			time:     "2026-10-19T06:30:00Z",
+			expected: nil,
+		},
+		{
+			name:     "before start",
+			time:     "2026-10-19T07:59:00+02:00",
+			expected: []string{"prod"},
+		},
+		{
+			name:     "at end",
+			time:     "2026-10-19T16:00:00+02:00",
+			expected: []string{"prod"},
+		},
+		{
+			name:     "weekend",
+			time:     "2026-10-18T10:00:00+02:00",
+			expected: []string{"prod"},
+		},
+		{
+			name:     "holiday",
+			time:     "2026-12-24T10:00:00+01:00",
+			expected: []string{"prod"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, tc.time)
+			require.NoError(t, err)
+
+			// Act
+			outside := windows.outside([]string{"dev", "prod"}, now)
+
+			// Assert
+			assert.Equal(t, tc.expected, outside)
+		})
+	}
+}
+
+func TestParseDeploymentWindowsInvalid(t *testing.T) {
+	tt := []struct {
+		name   string
+		config DeploymentWindowConfig
+	}{
+		{
+			name:   "unknown time zone",
+			config: DeploymentWindowConfig{TimeZone: "Mars/Olympus"},
+		},
+		{
+			name:   "unknown weekday",
+			config: DeploymentWindowConfig{Weekdays: []string{"Caturday"}},
+		},
+		{
+			name:   "start after end",
+			config: DeploymentWindowConfig{Start: "16:00", End: "08:00"},
+		},
+		{
+			name:   "invalid holiday",
+			config: DeploymentWindowConfig{Holidays: []string{"24/12/2026"}},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseDeploymentWindows(map[string]DeploymentWindowConfig{"prod": tc.config})
+
+			assert.Error(t, err)
+		})
+	}
+}
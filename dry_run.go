@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// dryRunHeader marks responses of requests skipped in dry-run mode.
+const dryRunHeader = "X-Release-Manager-Bot-Dry-Run"
+
+// dryRunLogSize is the number of latest skipped writes kept for the dry-run
+// admin route.
+const dryRunLogSize = 500
+
+// DryRunWrite is a write that would have been made if dry-run mode was
+// disabled.
+type DryRunWrite struct {
+	Time        time.Time       `json:"time"`
+	Destination string          `json:"destination"`
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	Delivery    string          `json:"delivery,omitempty"`
+}
+
+// DryRunLog keeps the latest writes skipped in dry-run mode in a ring buffer.
+type DryRunLog struct {
+	mu     sync.RWMutex
+	writes []DryRunWrite
+	next   int
+	full   bool
+}
+
+func NewDryRunLog(size int) *DryRunLog {
+	return &DryRunLog{
+		writes: make([]DryRunWrite, size),
+	}
+}
+
+// Add stores a write, overwriting the oldest one if the log is full.
+func (l *DryRunLog) Add(write DryRunWrite) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writes[l.next] = write
+	l.next = (l.next + 1) % len(l.writes)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// List returns the latest writes first. At most limit writes are returned
+// unless limit is 0.
+func (l *DryRunLog) List(limit int) []DryRunWrite {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	count := l.next
+	if l.full {
+		count = len(l.writes)
+	}
+	if limit > 0 && limit < count {
+		count = limit
+	}
+	writes := make([]DryRunWrite, 0, count)
+	for i := 1; i <= count; i++ {
+		writes = append(writes, l.writes[(l.next-i+len(l.writes))%len(l.writes)])
+	}
+	return writes
+}
+
+// ServeHTTP lists the latest skipped writes. The query parameter 'limit'
+// narrows down the result.
+func (l *DryRunLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var limit int
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(l.List(limit))
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("Failed to encode dry-run writes: %v", err)
+	}
+}
+
+// isWrite reports whether a request changes state at its destination. Github
+// GraphQL queries are sent with POST but only read.
+func isWrite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	case http.MethodPost:
+		return !strings.HasSuffix(r.URL.Path, "/graphql")
+	default:
+		return true
+	}
+}
+
+// dryRunMiddleware skips writes to destination, logging and recording them in
+// log instead. Skipped writes succeed with an empty response. Reads are sent.
+// Requests are not changed if log is nil.
+func dryRunMiddleware(destination string, log *DryRunLog) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		if log == nil {
+			return next
+		}
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			if !isWrite(r) {
+				return next.RoundTrip(r)
+			}
+
+			write := DryRunWrite{
+				Time:        time.Now(),
+				Destination: destination,
+				Method:      r.Method,
+				URL:         r.URL.Redacted(),
+			}
+			if r.Body != nil {
+				body, err := io.ReadAll(r.Body)
+				r.Body.Close()
+				if err != nil {
+					return nil, err
+				}
+				if json.Valid(body) {
+					write.Body = body
+				}
+			}
+			if record := deliveryFromContext(r.Context()); record != nil {
+				record.mu.Lock()
+				write.Delivery = record.delivery.ID
+				record.mu.Unlock()
+			}
+			log.Add(write)
+
+			logEvent := zerolog.Ctx(r.Context()).Info().
+				Str("dry_run_destination", destination).
+				Str("dry_run_method", write.Method).
+				Str("dry_run_url", write.URL)
+			if write.Body != nil {
+				logEvent = logEvent.RawJSON("dry_run_body", write.Body)
+			}
+			logEvent.Msgf("Dry-run: skipped %s %s", write.Method, write.URL)
+
+			status := http.StatusOK
+			if r.Method == http.MethodDelete {
+				status = http.StatusNoContent
+			}
+			return &http.Response{
+				Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+				StatusCode: status,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{dryRunHeader: []string{"true"}},
+				Body:       io.NopCloser(bytes.NewReader(nil)),
+				Request:    r,
+			}, nil
+		})
+	}
+}
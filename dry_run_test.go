@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsWrite(t *testing.T) {
+	tt := []struct {
+		method string
+		path   string
+		write  bool
+	}{
+		{method: http.MethodGet, path: "/repos/owner/repo/pulls/1", write: false},
+		{method: http.MethodHead, path: "/repos/owner/repo", write: false},
+		{method: http.MethodPost, path: "/graphql", write: false},
+		{method: http.MethodPost, path: "/repos/owner/repo/issues/1/comments", write: true},
+		{method: http.MethodPatch, path: "/repos/owner/repo/issues/comments/1", write: true},
+		{method: http.MethodPut, path: "/repos/owner/repo/issues/1/labels", write: true},
+		{method: http.MethodDelete, path: "/repos/owner/repo/issues/1/labels/dev", write: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			assert.Equal(t, tc.write, isWrite(r))
+		})
+	}
+}
+
+func TestDryRunMiddleware_github(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Method+" "+r.URL.Path)
+		_, _ = w.Write([]byte(`{"number":1}`))
+	}))
+	defer server.Close()
+
+	log := NewDryRunLog(10)
+	client := github.NewClient(&http.Client{Transport: dryRunMiddleware("github", log)(http.DefaultTransport)})
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	ctx := withDeliveryRecord(context.Background(), &deliveryRecord{delivery: Delivery{ID: "delivery-1"}})
+
+	pr, _, err := client.PullRequests.Get(ctx, "owner", "repo", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pr.GetNumber())
+
+	body := "'main' will auto-release to: dev"
+	_, res, err := client.Issues.CreateComment(ctx, "owner", "repo", 1, &github.IssueComment{Body: &body})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	_, _, err = client.Issues.AddLabelsToIssue(ctx, "owner", "repo", 1, []string{"auto-release:dev"})
+	require.NoError(t, err)
+
+	_, err = client.Issues.RemoveLabelForIssue(ctx, "owner", "repo", 1, "auto-release:prod")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"GET /repos/owner/repo/pulls/1"}, received, "requests sent")
+
+	writes := log.List(0)
+	require.Len(t, writes, 3)
+	assert.Equal(t, http.MethodDelete, writes[0].Method)
+	assert.Equal(t, server.URL+"/repos/owner/repo/issues/1/labels", writes[1].URL)
+	assert.JSONEq(t, `["auto-release:dev"]`, string(writes[1].Body))
+	assert.Equal(t, http.MethodPost, writes[2].Method)
+	assert.Equal(t, server.URL+"/repos/owner/repo/issues/1/comments", writes[2].URL)
+	assert.Equal(t, "github", writes[2].Destination)
+	assert.Equal(t, "delivery-1", writes[2].Delivery)
+	var comment github.IssueComment
+	require.NoError(t, json.Unmarshal(writes[2].Body, &comment))
+	assert.Equal(t, body, comment.GetBody(), "rendered comment body")
+}
+
+func TestDryRunMiddleware_disabled(t *testing.T) {
+	called := false
+	next := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		called = true
+		return &http.Response{StatusCode: http.StatusCreated}, nil
+	})
+
+	res, err := dryRunMiddleware("release-manager", nil)(next).RoundTrip(httptest.NewRequest(http.MethodPost, "/holds", nil))
+
+	require.NoError(t, err)
+	assert.True(t, called, "request sent")
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+}
+
+func TestDryRunLog(t *testing.T) {
+	log := NewDryRunLog(2)
+	for _, method := range []string{http.MethodPost, http.MethodPatch, http.MethodDelete} {
+		log.Add(DryRunWrite{Method: method})
+	}
+
+	writes := log.List(0)
+	require.Len(t, writes, 2)
+	assert.Equal(t, http.MethodDelete, writes[0].Method)
+	assert.Equal(t, http.MethodPatch, writes[1].Method)
+	assert.Len(t, log.List(1), 1)
+
+	w := httptest.NewRecorder()
+	log.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/dry-run?limit=invalid", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// explainCommand is the pull request comment that makes the bot explain its
+// decisions on the pull request.
+const explainCommand = "/release-manager explain"
+
+// pullRequestExplanation is the decision trace of the bot for a pull request.
+type pullRequestExplanation struct {
+	Branch         string
+	Service        string
+	ServiceRule    string
+	Ignored        bool
+	Artifacts      int
+	ArtifactsError error
+	Policies       ListPoliciesResponse
+	PoliciesError  error
+	AutoReleases   []autoReleaseMatch
+	Delivery       *Delivery
+}
+
+// autoReleaseMatch is an auto-release policy and whether it matches the base
+// branch of a pull request.
+type autoReleaseMatch struct {
+	Policy  AutoReleasePolicy
+	Kind    string
+	Matched bool
+}
+
+func (explanation pullRequestExplanation) autoReleaseEnvironments() []string {
+	var environments []string
+	for _, match := range explanation.AutoReleases {
+		if match.Matched {
+			environments = append(environments, match.Policy.Environment)
+		}
+	}
+	return environments
+}
+
+// handleIssueComment replies to explain commands on pull requests.
+func (handler *PRCreateHandler) handleIssueComment(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	var event github.IssueCommentEvent
+
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return errors.Wrap(err, "parsing payload")
+	}
+
+	repository := event.GetRepo()
+	issue := event.GetIssue()
+	installationID := githubapp.GetInstallationIDFromEvent(&event)
+
+	logger := zerolog.Ctx(ctx).With().
+		Int64("github_installation_id", installationID).
+		Str("github_repository_owner", repository.GetOwner().GetLogin()).
+		Str("github_repository_name", repository.GetName()).
+		Int("github_pr_num", issue.GetNumber()).
+		Logger()
+	ctx = logger.WithContext(ctx)
+
+	logger.Info().Msgf("Handling deliveryID: '%s', eventType '%s'", deliveryID, eventType)
+
+	// Filters
+	// - Disabled
+	if checkFilter(ctx, "ExplainDisabled", func() bool {
+		if handler.explainCommand {
+			return false
+		}
+		logger.Info().Msg("Filter ExplainDisabled triggered")
+		return true
+	}) {
+		return nil
+	}
+	// - Action type
+	if checkFilter(ctx, "ActionType", func() bool {
+		if event.GetAction() == "created" {
+			return false
+		}
+		logger.Info().Msgf("Filter ActionType triggered. Action: '%s'", event.GetAction())
+		return true
+	}) {
+		return nil
+	}
+	// - Comments on issues
+	if checkFilter(ctx, "NotPullRequest", func() bool {
+		if issue.IsPullRequest() {
+			return false
+		}
+		logger.Info().Msg("Filter NotPullRequest triggered")
+		return true
+	}) {
+		return nil
+	}
+	// - Other comments
+	if checkFilter(ctx, "NotCommand", func() bool {
+		if strings.TrimSpace(event.GetComment().GetBody()) == explainCommand {
+			return false
+		}
+		logger.Info().Msg("Filter NotCommand triggered")
+		return true
+	}) {
+		return nil
+	}
+
+	client, err := handler.NewInstallationClient(installationID)
+	if err != nil {
+		return errors.Wrapf(err, "creating new github.Client from installation id '%d'", installationID)
+	}
+
+	owner := repository.GetOwner().GetLogin()
+	repo := repository.GetName()
+	pr, _, err := client.PullRequests.Get(ctx, owner, repo, issue.GetNumber())
+	if err != nil {
+		return errors.Wrapf(err, "getting PR %d", issue.GetNumber())
+	}
+
+	explanation := handler.explainPullRequest(ctx, repository, pr)
+	deliveryService(ctx, explanation.Service)
+	body := fmt.Sprintf("@%s\n\n%s", event.GetComment().GetUser().GetLogin(), formatExplanation(explanation, time.Now()))
+
+	_, _, err = client.Issues.CreateComment(ctx, owner, repo, issue.GetNumber(), &github.IssueComment{
+		Body: &body,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "creating explanation comment on PR %d", issue.GetNumber())
+	}
+	logger.Info().Msgf("Explanation comment created on %s PR %d", repo, issue.GetNumber())
+	return nil
+}
+
+// explainPullRequest evaluates the decisions made for a pull request. Failing
+// release-manager requests are part of the explanation rather than errors.
+func (handler *PRCreateHandler) explainPullRequest(ctx context.Context, repository *github.Repository, pr *github.PullRequest) pullRequestExplanation {
+	explanation := pullRequestExplanation{
+		Branch: pr.GetBase().GetRef(),
+		Ignored: any(handler.repoFilters, func(filterRepo string) bool {
+			return filterRepo == repository.GetName()
+		}),
+	}
+	explanation.Service, explanation.ServiceRule = resolveServiceName(repository.GetName(), handler.config(ctx).RepoToServiceMap)
+
+	artifacts, err := handler.retrieveArtifacts(ctx, explanation.Service)
+	explanation.Artifacts = len(artifacts)
+	explanation.ArtifactsError = err
+
+	explanation.Policies, explanation.PoliciesError = handler.retrievePolicies(ctx, explanation.Service)
+	for _, policy := range explanation.Policies.AutoReleases {
+		explanation.AutoReleases = append(explanation.AutoReleases, autoReleaseMatch{
+			Policy:  policy,
+			Kind:    branchPatternKind(policy.Branch),
+			Matched: branchMatches(policy.Branch, explanation.Branch),
+		})
+	}
+
+	if handler.deliveries != nil {
+		deliveries := handler.deliveries.List(repository.GetFullName(), pr.GetNumber(), 0)
+		for i := range deliveries {
+			if deliveries[i].Event == "pull_request" {
+				explanation.Delivery = &deliveries[i]
+				break
+			}
+		}
+	}
+	return explanation
+}
+
+func formatExplanation(explanation pullRequestExplanation, now time.Time) string {
+	var b strings.Builder
+
+	b.WriteString("### Release-manager bot decisions\n\n")
+	fmt.Fprintf(&b, "**Service:** `%s`, %s.\n\n", explanation.Service, explanation.ServiceRule)
+
+	b.WriteString("**Filters**\n")
+	if explanation.Ignored {
+		b.WriteString("- :x: IgnoredRepo: the repository is in --ignored-repositories\n")
+	} else {
+		b.WriteString("- :white_check_mark: IgnoredRepo: the repository is not ignored\n")
+	}
+	switch {
+	case explanation.ArtifactsError != nil:
+		fmt.Fprintf(&b, "- :warning: UnmanagedService: retrieving artifacts failed: %v\n", explanation.ArtifactsError)
+	case explanation.Artifacts == 0:
+		fmt.Fprintf(&b, "- :x: UnmanagedService: release-manager has no artifacts of `%s`\n", explanation.Service)
+	default:
+		fmt.Fprintf(&b, "- :white_check_mark: UnmanagedService: release-manager has %d recent artifacts of `%s`\n", explanation.Artifacts, explanation.Service)
+	}
+	b.WriteString("- Comments are only made when a pull request is opened or its base branch is changed\n\n")
+
+	if explanation.Delivery != nil {
+		delivery := explanation.Delivery
+		fmt.Fprintf(&b, "**Latest delivery:** `%s` (%s %s) %s ago was %s", delivery.ID, delivery.Event, delivery.Action, now.Sub(delivery.ReceivedAt).Round(time.Second), delivery.Outcome)
+		if len(delivery.Filters) > 0 {
+			fmt.Fprintf(&b, " by %s", strings.Join(delivery.Filters, ", "))
+		}
+		if delivery.Error != "" {
+			fmt.Fprintf(&b, ": %s", delivery.Error)
+		}
+		b.WriteString("\n\n")
+	}
+
+	fmt.Fprintf(&b, "**Auto-releases from `%s`**\n", explanation.Branch)
+	switch {
+	case explanation.PoliciesError != nil:
+		fmt.Fprintf(&b, "Retrieving policies failed: %v\n", explanation.PoliciesError)
+	case len(explanation.AutoReleases) == 0:
+		fmt.Fprintf(&b, "`%s` has no auto-release policies.\n", explanation.Service)
+	default:
+		b.WriteString("| Branch | Kind | Environment | Matches |\n|---|---|---|---|\n")
+		for _, match := range explanation.AutoReleases {
+			matched := ":x:"
+			if match.Matched {
+				matched = ":white_check_mark:"
+			}
+			fmt.Fprintf(&b, "| `%s` | %s | `%s` | %s |\n", match.Policy.Branch, match.Kind, match.Policy.Environment, matched)
+		}
+		environments := explanation.autoReleaseEnvironments()
+		if len(environments) == 0 {
+			b.WriteString("\nMerging does not auto-release.\n")
+		} else {
+			fmt.Fprintf(&b, "\nMerging auto-releases to `%s`.\n", strings.Join(environments, "`, `"))
+		}
+	}
+
+	if explanation.PoliciesError == nil {
+		policies, err := json.MarshalIndent(explanation.Policies, "", "  ")
+		if err == nil {
+			fmt.Fprintf(&b, "\n<details><summary>Policies retrieved from release-manager</summary>\n\n```json\n%s\n```\n</details>\n", policies)
+		}
+	}
+
+	return b.String()
+}
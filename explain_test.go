@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatExplanation(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+	policies := ListPoliciesResponse{
+		Service: "product",
+		AutoReleases: []AutoReleasePolicy{
+			{ID: "auto-release-master-dev", Branch: "master", Environment: "dev"},
+			{ID: "auto-release-release-staging", Branch: "release/*", Environment: "staging"},
+		},
+	}
+
+	tt := []struct {
+		name        string
+		explanation pullRequestExplanation
+		contains    []string
+		notContains []string
+	}{
+		{
+			name: "auto-release",
+			explanation: pullRequestExplanation{
+				Branch:      "release/2026-10",
+				Service:     "product",
+				ServiceRule: "repository name 'lunar-way-product-service' without prefix 'lunar-way-' and suffix '-service'",
+				Artifacts:   3,
+				Policies:    policies,
+				AutoReleases: []autoReleaseMatch{
+					{Policy: policies.AutoReleases[0], Kind: branchPatternExact},
+					{Policy: policies.AutoReleases[1], Kind: branchPatternGlob, Matched: true},
+				},
+				Delivery: &Delivery{
+					ID:         "72d3162e",
+					Event:      "pull_request",
+					Action:     "opened",
+					ReceivedAt: now.Add(-90 * time.Second),
+					Outcome:    DeliveryOutcomeCompleted,
+				},
+			},
+			contains: []string{
+				"**Service:** `product`, repository name 'lunar-way-product-service'",
+				":white_check_mark: IgnoredRepo",
+				":white_check_mark: UnmanagedService: release-manager has 3 recent artifacts of `product`",
+				"**Latest delivery:** `72d3162e` (pull_request opened) 1m30s ago was completed",
+				"| `master` | exact | `dev` | :x: |",
+				"| `release/*` | glob | `staging` | :white_check_mark: |",
+				"Merging auto-releases to `staging`.",
+				`"id": "auto-release-master-dev"`,
+			},
+		},
+		{
+			name: "filtered",
+			explanation: pullRequestExplanation{
+				Branch:      "feature",
+				Service:     "product",
+				ServiceRule: "mapped from repository 'product' by --map-repo-to-service",
+				Ignored:     true,
+				Delivery: &Delivery{
+					ID:         "72d3162e",
+					Event:      "pull_request",
+					Action:     "opened",
+					ReceivedAt: now,
+					Outcome:    DeliveryOutcomeFiltered,
+					Filters:    []string{"UnmanagedService"},
+				},
+			},
+			contains: []string{
+				":x: IgnoredRepo",
+				":x: UnmanagedService: release-manager has no artifacts of `product`",
+				"0s ago was filtered by UnmanagedService",
+				"`product` has no auto-release policies.",
+			},
+		},
+		{
+			name: "release-manager failing",
+			explanation: pullRequestExplanation{
+				Branch:         "master",
+				Service:        "product",
+				ArtifactsError: errors.New("expected status code 200, but recieved 503"),
+				PoliciesError:  errors.New("expected status code 200, but recieved 503"),
+			},
+			contains: []string{
+				":warning: UnmanagedService: retrieving artifacts failed",
+				"Retrieving policies failed",
+			},
+			notContains: []string{
+				"Latest delivery",
+				"Policies retrieved from release-manager",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			message := formatExplanation(tc.explanation, now)
+
+			for _, s := range tc.contains {
+				assert.Contains(t, message, s)
+			}
+			for _, s := range tc.notContains {
+				assert.NotContains(t, message, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// GitHub truncates commit status descriptions longer than this.
+const maxStatusDescriptionLength = 140
+
+// lockedEnvironments returns the locks on any of the environments that have
+// not expired at now.
+func lockedEnvironments(locks []EnvironmentLock, environments []string, now time.Time) []EnvironmentLock {
+	var locked []EnvironmentLock
+	for _, lock := range locks {
+		if !lock.Until.IsZero() && !now.Before(lock.Until) {
+			continue
+		}
+		if any(environments, func(environment string) bool {
+			return environment == lock.Environment
+		}) {
+			locked = append(locked, lock)
+		}
+	}
+	return locked
+}
+
+// nextLockExpiry returns the earliest time after now a lock on any of the
+// environments expires, or the zero time if none do.
+func nextLockExpiry(locks []EnvironmentLock, environments []string, now time.Time) time.Time {
+	var next time.Time
+	for _, lock := range lockedEnvironments(locks, environments, now) {
+		if !lock.Until.IsZero() && (next.IsZero() || lock.Until.Before(next)) {
+			next = lock.Until
+		}
+	}
+	return next
+}
+
+// freezeStatus returns a commit status that fails while any of the
+// auto-release environments are locked or frozen at now.
+func freezeStatus(statusContext string, autoReleaseEnvironments []string, locks []EnvironmentLock, now time.Time) github.RepoStatus {
+	status := github.RepoStatus{
+		Context: github.Ptr(statusContext),
+	}
+
+	if len(autoReleaseEnvironments) == 0 {
+		status.State = github.Ptr("success")
+		status.Description = github.Ptr("No auto-release")
+		return status
+	}
+
+	locked := lockedEnvironments(locks, autoReleaseEnvironments, now)
+	if len(locked) == 0 {
+		status.State = github.Ptr("success")
+		status.Description = github.Ptr(fmt.Sprintf("%s open for auto-release", strings.Join(autoReleaseEnvironments, ", ")))
+		return status
+	}
+
+	var descriptions []string
+	for _, lock := range locked {
+		kind := lock.Kind
+		if kind == "" {
+			kind = "lock"
+		}
+		description := fmt.Sprintf("%s %s by %s", lock.Environment, kind, lock.LockedBy)
+		if lock.Reason != "" {
+			description += ": " + lock.Reason
+		}
+		descriptions = append(descriptions, description)
+	}
+	status.State = github.Ptr("failure")
+	status.Description = github.Ptr(truncate(strings.Join(descriptions, "; "), maxStatusDescriptionLength))
+	return status
+}
+
+// truncate shortens s to length characters ending with an ellipsis.
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length-3]) + "..."
+}
+
+func (handler *PRCreateHandler) retrieveLocks(ctx context.Context, serviceName string) ([]EnvironmentLock, error) {
+	var locksResponse ListLocksResponse
+	err := retrieveFromReleaseManager(ctx, handler.releaseManagerURL+"/locks?service="+serviceName, handler.config(ctx).ReleaseManagerAuthToken, &locksResponse, handler.releaseManagerMetricsMiddleware)
+	if err != nil {
+		return nil, errors.Wrap(err, "requesting locks from release manager")
+	}
+	return locksResponse.Locks, nil
+}
+
+// setStatus creates the commit status on sha unless the latest status with the
+// same context already has the same state and description. It reports whether
+// the status was created.
+func (handler *PRCreateHandler) setStatus(ctx context.Context, client *github.Client, owner, repo, sha string, status github.RepoStatus) (bool, error) {
+	logger := zerolog.Ctx(ctx)
+
+	statuses, _, err := client.Repositories.ListStatuses(ctx, owner, repo, sha, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return false, errors.Wrapf(err, "listing statuses for commit '%s'", sha)
+	}
+	// Statuses are listed in reverse chronological order
+	for _, existing := range statuses {
+		if existing.GetContext() != status.GetContext() {
+			continue
+		}
+		if existing.GetState() == status.GetState() && existing.GetDescription() == status.GetDescription() {
+			logger.Debug().Msgf("Status '%s' for commit %s is unchanged", status.GetContext(), sha)
+			return false, nil
+		}
+		break
+	}
+
+	if _, _, err := client.Repositories.CreateStatus(ctx, owner, repo, sha, &status); err != nil {
+		return false, errors.Wrapf(err, "creating status '%s' for commit '%s'", status.GetContext(), sha)
+	}
+	logger.Info().Msgf("Status '%s' set to '%s' for commit %s", status.GetContext(), status.GetState(), sha)
+	return true, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreezeStatus(t *testing.T) {
+	now := time.Date(2026, 12, 27, 12, 0, 0, 0, time.UTC)
+	locks := []EnvironmentLock{
+		{Environment: "prod", Kind: "freeze", LockedBy: "alice", Reason: "Christmas"},
+		{Environment: "staging", LockedBy: "bob"},
+	}
+
+	tt := []struct {
+		name                    string
+		autoReleaseEnvironments []string
+		locks                   []EnvironmentLock
+		expectedState           string
+		expectedDescription     string
+	}{
+		{
+			name:                "no auto-release",
+			locks:               locks,
+			expectedState:       "success",
+			expectedDescription: "No auto-release",
+		},
+		{
+			name:                    "environments open",
+			autoReleaseEnvironments: []string{"dev"},
+			locks:                   locks,
+			expectedState:           "success",
+			expectedDescription:     "dev open for auto-release",
+		},
+		{
+			name:                    "environment frozen",
+			autoReleaseEnvironments: []string{"dev", "prod"},
+			locks:                   locks,
+			expectedState:           "failure",
+			expectedDescription:     "prod freeze by alice: Christmas",
+		},
+		{
+			name:                    "environments locked and frozen",
+			autoReleaseEnvironments: []string{"staging", "prod"},
+			locks:                   locks,
+			expectedState:           "failure",
+			expectedDescription:     "prod freeze by alice: Christmas; staging lock by bob",
+		},
+		{
+			name:                    "freeze expired",
+			autoReleaseEnvironments: []string{"prod"},
+			locks:                   []EnvironmentLock{{Environment: "prod", Kind: "freeze", LockedBy: "alice", Until: now}},
+			expectedState:           "success",
+			expectedDescription:     "prod open for auto-release",
+		},
+		{
+			name:                    "freeze until later",
+			autoReleaseEnvironments: []string{"prod"},
+			locks:                   []EnvironmentLock{{Environment: "prod", Kind: "freeze", LockedBy: "alice", Until: now.Add(time.Hour)}},
+			expectedState:           "failure",
+			expectedDescription:     "prod freeze by alice",
+		},
+		{
+			name:                    "long description",
+			autoReleaseEnvironments: []string{"prod"},
+			locks:                   []EnvironmentLock{{Environment: "prod", LockedBy: "alice", Reason: strings.Repeat("a", 200)}},
+			expectedState:           "failure",
+			expectedDescription:     "prod lock by alice: " + strings.Repeat("a", 117) + "...",
+		},
+		{
+			name:                    "long non-ASCII description",
+			autoReleaseEnvironments: []string{"prod"},
+			locks:                   []EnvironmentLock{{Environment: "prod", LockedBy: "alice", Reason: strings.Repeat("æ", 200)}},
+			expectedState:           "failure",
+			expectedDescription:     "prod lock by alice: " + strings.Repeat("æ", 117) + "...",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			status := freezeStatus("release-manager/freeze", tc.autoReleaseEnvironments, tc.locks, now)
+
+			// Assert
+			assert.Equal(t, "release-manager/freeze", status.GetContext())
+			assert.Equal(t, tc.expectedState, status.GetState())
+			assert.Equal(t, tc.expectedDescription, status.GetDescription())
+		})
+	}
+}
+
+func TestNextLockExpiry(t *testing.T) {
+	now := time.Date(2026, 12, 27, 12, 0, 0, 0, time.UTC)
+	locks := []EnvironmentLock{
+		{Environment: "prod", Kind: "freeze", Until: now.Add(2 * time.Hour)},
+		{Environment: "prod", LockedBy: "alice"},
+		{Environment: "staging", Kind: "freeze", Until: now.Add(time.Hour)},
+		{Environment: "dev", Kind: "freeze", Until: now.Add(-time.Hour)},
+	}
+
+	assert.Equal(t, now.Add(2*time.Hour), nextLockExpiry(locks, []string{"prod"}, now))
+	assert.Equal(t, now.Add(time.Hour), nextLockExpiry(locks, []string{"prod", "staging"}, now))
+	assert.True(t, nextLockExpiry(locks, []string{"dev"}, now).IsZero(), "expired")
+}
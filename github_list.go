@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+)
+
+// listInstallationIDs returns the IDs of all installations of the app.
+func listInstallationIDs(ctx context.Context, cc githubapp.ClientCreator) ([]int64, error) {
+	appClient, err := cc.NewAppClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating new github.Client for app")
+	}
+
+	var installationIDs []int64
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		installations, resp, err := appClient.Apps.ListInstallations(ctx, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing installations")
+		}
+		for _, installation := range installations {
+			installationIDs = append(installationIDs, installation.GetID())
+		}
+		if resp.NextPage == 0 {
+			return installationIDs, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// listRepositories returns the repositories accessible to an installation.
+func listRepositories(ctx context.Context, client *github.Client) ([]*github.Repository, error) {
+	var repositories []*github.Repository
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.Apps.ListRepos(ctx, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing repositories")
+		}
+		repositories = append(repositories, page.Repositories...)
+		if resp.NextPage == 0 {
+			return repositories, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
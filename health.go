@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// dependencyCheck verifies that a dependency is reachable and configured.
+type dependencyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// ReadinessResponse is the result of the readiness checks. Checks maps check
+// names to "ok" or the error of the check.
+type ReadinessResponse struct {
+	Ready     bool              `json:"ready"`
+	CheckedAt time.Time         `json:"checkedAt"`
+	Checks    map[string]string `json:"checks"`
+}
+
+// readinessChecker runs dependency checks at most once per cacheTTL so probes
+// do not hammer release-manager and GitHub.
+type readinessChecker struct {
+	checks   []dependencyCheck
+	cacheTTL time.Duration
+	timeout  time.Duration
+
+	shuttingDown atomic.Bool
+
+	mu     sync.Mutex
+	cached ReadinessResponse
+}
+
+// Shutdown makes the checker report not ready from now on so no new requests
+// are routed to the instance.
+func (c *readinessChecker) Shutdown() {
+	c.shuttingDown.Store(true)
+}
+
+// Check returns the cached result of the checks or runs them if it is older
+// than the cache TTL.
+func (c *readinessChecker) Check(ctx context.Context) ReadinessResponse {
+	if c.shuttingDown.Load() {
+		return ReadinessResponse{
+			CheckedAt: time.Now(),
+			Checks:    map[string]string{"shutdown": "shutting down"},
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.cached.CheckedAt.IsZero() && time.Since(c.cached.CheckedAt) < c.cacheTTL {
+		return c.cached
+	}
+	c.cached = c.run(ctx)
+	return c.cached
+}
+
+func (c *readinessChecker) run(ctx context.Context) ReadinessResponse {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	response := ReadinessResponse{
+		Ready:     true,
+		CheckedAt: time.Now(),
+		Checks:    make(map[string]string, len(c.checks)),
+	}
+	for _, check := range c.checks {
+		err := check.check(ctx)
+		if err != nil {
+			response.Ready = false
+			response.Checks[check.name] = err.Error()
+			continue
+		}
+		response.Checks[check.name] = "ok"
+	}
+	return response
+}
+
+func (c *readinessChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response := c.Check(r.Context())
+	if !response.Ready {
+		zerolog.Ctx(r.Context()).Info().Msgf("Readiness check failed: %v", response.Checks)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !response.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("Failed to encode readiness response: %v", err)
+	}
+}
+
+// livenessHandler reports that the process is able to serve requests.
+func livenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("ok"))
+	})
+}
+
+// releaseManagerCheck verifies that release-manager is reachable and accepts
+// the current authToken. Any response but 401, 403 and 5xx means the request was
+// authenticated, as the endpoint may not know the service.
+func releaseManagerCheck(endpoint string, authToken func() string, transport http.RoundTripper) func(ctx context.Context) error {
+	httpClient := &http.Client{Transport: transport}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return errors.Wrapf(err, "create GET request for release-manager endpoint '%s'", endpoint)
+		}
+		req.Header.Add("Authorization", "Bearer "+authToken())
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return errors.Wrap(err, "release-manager unreachable")
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return errors.Errorf("release-manager rejected the auth token with status code %d", resp.StatusCode)
+		case resp.StatusCode >= 500:
+			return errors.Errorf("release-manager unavailable with status code %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// githubAppCheck verifies that the GitHub App JWT can list installations.
+func githubAppCheck(cc githubapp.ClientCreator) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		appClient, err := cc.NewAppClient()
+		if err != nil {
+			return errors.Wrap(err, "creating new github.Client for app")
+		}
+		_, _, err = appClient.Apps.ListInstallations(ctx, &github.ListOptions{PerPage: 1})
+		if err != nil {
+			return errors.Wrap(err, "listing installations")
+		}
+		return nil
+	}
+}
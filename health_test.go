@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadinessChecker(t *testing.T) {
+	var calls int
+	var checkErr error
+	checker := &readinessChecker{
+		checks: []dependencyCheck{
+			{name: "ok", check: func(ctx context.Context) error { return nil }},
+			{name: "flaky", check: func(ctx context.Context) error {
+				calls++
+				return checkErr
+			}},
+		},
+		cacheTTL: time.Hour,
+		timeout:  time.Second,
+	}
+
+	recorder := httptest.NewRecorder()
+	checker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// Cached result is served while the dependency fails
+	checkErr = errors.New("unreachable")
+	recorder = httptest.NewRecorder()
+	checker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 1, calls)
+
+	// Expired result is checked again
+	checker.cacheTTL = 0
+	recorder = httptest.NewRecorder()
+	checker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, map[string]string{"ok": "ok", "flaky": "unreachable"}, checker.cached.Checks)
+	assert.Equal(t, 2, calls)
+}
+
+func TestReleaseManagerCheck(t *testing.T) {
+	tt := []struct {
+		name          string
+		statusCode    int
+		expectedError bool
+	}{
+		{name: "ok", statusCode: http.StatusOK},
+		{name: "unknown service", statusCode: http.StatusNotFound},
+		{name: "unauthorized", statusCode: http.StatusUnauthorized, expectedError: true},
+		{name: "forbidden", statusCode: http.StatusForbidden, expectedError: true},
+		{name: "unavailable", statusCode: http.StatusServiceUnavailable, expectedError: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+				w.WriteHeader(tc.statusCode)
+			}))
+			defer server.Close()
+
+			err := releaseManagerCheck(server.URL+"/policies?service=release-manager-bot", func() string { return "token" }, http.DefaultTransport)(context.Background())
+
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestReadinessChecker_Shutdown(t *testing.T) {
+	checker := &readinessChecker{
+		checks: []dependencyCheck{
+			{name: "ok", check: func(ctx context.Context) error { return nil }},
+		},
+		cacheTTL: time.Hour,
+		timeout:  time.Second,
+	}
+	assert.True(t, checker.Check(context.Background()).Ready)
+
+	checker.Shutdown()
+
+	recorder := httptest.NewRecorder()
+	checker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
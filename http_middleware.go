@@ -1,9 +1,11 @@
 package main
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/palantir/go-githubapp/githubapp"
@@ -207,3 +209,16 @@ func githubMetricsMiddleware(promRegisterer prometheus.Registerer) githubapp.Cli
 		})
 	}
 }
+
+// bearerAuthMiddleware only lets requests authenticated with authToken as
+// bearer token through to h.
+func bearerAuthMiddleware(authToken string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if authToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(authToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
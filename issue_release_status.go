@@ -1,12 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/go-github/v69/github"
@@ -15,25 +17,94 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// describeArtifactCount is the number of recent artifacts requested from
+// release-manager. It must be large enough to cover the artifacts currently
+// deployed to the auto-release environments.
+const describeArtifactCount = 50
+
 // Structs
 type PRCreateHandler struct {
 	githubapp.ClientCreator
 	preamble string
 
 	releaseManagerMetricsMiddleware http.RoundTripper
-	releaseManagerAuthToken         string
 	releaseManagerURL               string
-	messageTemplate                 string
 	repoFilters                     []string
 	logger                          zerolog.Logger
-	repoToServiceMap                map[string]string
+	releaseReadinessCheck           bool
+	releaseReadinessCheckName       string
+	requestSquadReview              bool
+	autoReleaseLabels               bool
+	autoReleaseLabelPrefix          string
+	autoReleaseLabelColor           string
+	autoReleaseLabelColors          map[string]string
+	releaseHoldLabel                string
+	freezeStatus                    bool
+	freezeStatusContext             string
+	deploymentWindowStatus          bool
+	deploymentWindowStatusContext   string
+	policyFilePath                  string
+	explainCommand                  bool
+	deliveries                      *DeliveryLog
+	reconcileServiceAt              func(service string, at time.Time)
+	reloadable                      atomic.Pointer[ReloadableConfig]
+}
+
+type configContextKey struct{}
+
+// withConfig returns a context handled with config, so a delivery uses the
+// same configuration throughout even if it is reloaded meanwhile.
+func withConfig(ctx context.Context, config *ReloadableConfig) context.Context {
+	return context.WithValue(ctx, configContextKey{}, config)
+}
+
+// config returns the configuration ctx is handled with, or the current
+// reloadable configuration if it is not set.
+func (handler *PRCreateHandler) config(ctx context.Context) *ReloadableConfig {
+	if config, ok := ctx.Value(configContextKey{}).(*ReloadableConfig); ok {
+		return config
+	}
+	return handler.reloadable.Load()
+}
+
+// serviceName returns the service name of a repository with the current
+// reloadable configuration.
+func (handler *PRCreateHandler) serviceName(repoName string) string {
+	return getServiceName(repoName, handler.reloadable.Load().RepoToServiceMap)
 }
 
 func (handler *PRCreateHandler) Handles() []string {
-	return []string{"pull_request"}
+	return []string{"pull_request", "check_run", "issue_comment"}
 }
 
 func (handler *PRCreateHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	ctx = withConfig(ctx, handler.config(ctx))
+
+	switch eventType {
+	case "check_run":
+		return handler.handleCheckRun(ctx, eventType, deliveryID, payload)
+	case "issue_comment":
+		return handler.handleIssueComment(ctx, eventType, deliveryID, payload)
+	}
+
+	err := handler.handlePullRequest(ctx, eventType, deliveryID, payload)
+	if handler.policyFilePath == "" {
+		return err
+	}
+
+	// Policy files are handled independently of auto-release comments
+	policyErr := handler.handlePolicyFile(ctx, deliveryID, payload)
+	if policyErr != nil {
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Msgf("Failed to handle policy file: %v", policyErr)
+			return err
+		}
+		return errors.Wrap(policyErr, "handling policy file")
+	}
+	return err
+}
+
+func (handler *PRCreateHandler) handlePullRequest(ctx context.Context, eventType, deliveryID string, payload []byte) error {
 	// Receive webhook
 	var event github.PullRequestEvent
 
@@ -59,71 +130,116 @@ func (handler *PRCreateHandler) Handle(ctx context.Context, eventType, deliveryI
 
 	// Get info from event
 	prBase := event.GetPullRequest().GetBase().GetRef()
-	policyPath := handler.releaseManagerURL + "/policies?service="
-	describeArtifactPath := handler.releaseManagerURL + "/describe/artifact/"
+	prHeadSHA := event.GetPullRequest().GetHead().GetSHA()
 
 	// Get service name
-	serviceName := getServiceName(event.GetRepo().GetName(), handler.repoToServiceMap)
+	serviceName := getServiceName(event.GetRepo().GetName(), handler.config(ctx).RepoToServiceMap)
+	deliveryService(ctx, serviceName)
 
 	// Filters - Consider using Chain of Responsibility for this if it gets bloated.
 	// - Action type
-	if event.GetAction() != "opened" && event.GetAction() != "edited" {
+	holdAction := false
+	if checkFilter(ctx, "ActionType", func() bool {
+		switch event.GetAction() {
+		case "opened", "edited":
+			return false
+		case "reopened", "synchronize":
+			if handler.releaseReadinessCheck || handler.freezeStatus || handler.deploymentWindowStatus {
+				return false
+			}
+		case "closed", "labeled", "unlabeled":
+			if handler.releaseHoldLabel != "" {
+				holdAction = true
+				return false
+			}
+		}
 		logger.Info().Msgf("Filter ActionType triggered. Action: '%s'", event.GetAction())
+		return true
+	}) {
 		return nil
 	}
+	// - Release holds are only placed on merged pull requests and by the hold label
+	if holdAction {
+		if checkFilter(ctx, "NotMerged", func() bool {
+			if event.GetPullRequest().GetMerged() {
+				return false
+			}
+			logger.Info().Msg("Filter NotMerged triggered")
+			return true
+		}) {
+			return nil
+		}
+		if checkFilter(ctx, "OtherLabel", func() bool {
+			if event.GetAction() == "closed" || event.GetLabel().GetName() == handler.releaseHoldLabel {
+				return false
+			}
+			logger.Info().Msgf("Filter OtherLabel triggered. Label: '%s'", event.GetLabel().GetName())
+			return true
+		}) {
+			return nil
+		}
+	}
 	// - Edited; but no change in base branch
 	if event.GetAction() == "edited" {
-		if event.Changes == nil {
+		if checkFilter(ctx, "NoChanges", func() bool {
+			if event.Changes != nil {
+				return false
+			}
 			logger.Info().Msg("Filter NoChanges triggered") // Check in some weeks if this state has ever been triggered 25/08/2020
+			return true
+		}) {
 			return nil
-		} else if event.Changes.Base == nil { // to prevent nil dereference
+		}
+		if checkFilter(ctx, "NoBaseChanges", func() bool {
+			if event.Changes.Base != nil { // to prevent nil dereference
+				return false
+			}
 			logger.Info().Msg("Filter NoBaseChanges triggered")
+			return true
+		}) {
 			return nil
 		}
 	}
 	// - Services not managed by release-manager
-	var describeArtifactResponse DescribeArtifactResponse
-	err := retrieveFromReleaseManager(describeArtifactPath+serviceName, handler.releaseManagerAuthToken, &describeArtifactResponse, logger, handler.releaseManagerMetricsMiddleware)
+	artifacts, err := handler.retrieveArtifacts(ctx, serviceName)
 	if err != nil {
-		return errors.Wrap(err, "requesting describeArtifact from release manager")
+		return err
 	}
-	if len(describeArtifactResponse.Artifacts) == 0 {
+	if checkFilter(ctx, "UnmanagedService", func() bool {
+		if len(artifacts) > 0 {
+			return false
+		}
 		logger.Info().Msgf("Filter UnmanagedService triggered. Service: '%s'", serviceName)
+		return true
+	}) {
 		return nil
 	}
 	// - Ignored repositories
-	if any(handler.repoFilters, func(filterRepo string) bool {
-		return filterRepo == repository.GetName()
-	}) {
+	if checkFilter(ctx, "IgnoredRepo", func() bool {
+		if !any(handler.repoFilters, func(filterRepo string) bool {
+			return filterRepo == repository.GetName()
+		}) {
+			return false
+		}
 		logger.Info().Msgf("Filter IgnoredRepo triggered. Repo: '%s'", repository.GetName())
+		return true
+	}) {
 		return nil
 	}
 
 	// Get policies
-	var policyResponse ListPoliciesResponse
-	err = retrieveFromReleaseManager(policyPath+serviceName, handler.releaseManagerAuthToken, &policyResponse, logger, handler.releaseManagerMetricsMiddleware)
+	policies, err := handler.retrievePolicies(ctx, serviceName)
 	if err != nil {
-		return errors.Wrap(err, "requesting policy from release manager")
+		return err
 	}
 
-	var autoReleaseEnvironments []string
-	for i := 0; i < len(policyResponse.AutoReleases); i++ {
-		if policyResponse.AutoReleases[i].Branch == prBase {
-			autoReleaseEnvironments = append(autoReleaseEnvironments, policyResponse.AutoReleases[i].Environment)
-		}
+	service := serviceState{
+		name:      serviceName,
+		artifacts: artifacts,
+		policies:  policies,
 	}
+	autoReleaseEnvironments := matchAutoReleases(policies.AutoReleases, prBase)
 
-	messageData := BotMessageData{
-		Branch:                  prBase,
-		AutoReleaseEnvironments: autoReleaseEnvironments,
-		Template:                handler.messageTemplate,
-	}
-	botMessage, err := BotMessage(messageData)
-	if err != nil {
-		return errors.Wrapf(err, "creating bot message")
-	}
-
-	// Send PR comment
 	client, err := handler.NewInstallationClient(installationID)
 	if err != nil {
 		return errors.Wrapf(err, "creating new github.Client from installation id '%d'", installationID)
@@ -132,6 +248,56 @@ func (handler *PRCreateHandler) Handle(ctx context.Context, eventType, deliveryI
 	repositoryOwner := repository.GetOwner().GetLogin()
 	repositoryName := repository.GetName()
 
+	// Release holds
+	switch event.GetAction() {
+	case "closed", "labeled", "unlabeled":
+		return handler.updateReleaseHold(ctx, client, &event, serviceName, autoReleaseEnvironments)
+	}
+
+	// Checks and statuses
+	if handler.freezeStatus && len(autoReleaseEnvironments) > 0 {
+		service.locks, err = handler.retrieveLocks(ctx, serviceName)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = handler.updateChecks(ctx, client, repositoryOwner, repositoryName, prHeadSHA, service, autoReleaseEnvironments, false)
+	if err != nil {
+		return err
+	}
+
+	// - Comments are only made when the pull request is opened or its base changed
+	if checkFilter(ctx, "CommentAction", func() bool {
+		if event.GetAction() == "opened" || event.GetAction() == "edited" {
+			return false
+		}
+		logger.Info().Msgf("Filter CommentAction triggered. Action: '%s'", event.GetAction())
+		return true
+	}) {
+		return nil
+	}
+
+	// Sync auto-release labels
+	if handler.autoReleaseLabels {
+		_, err = handler.syncAutoReleaseLabels(ctx, client, repositoryOwner, repositoryName, prNum, event.GetPullRequest().Labels, autoReleaseEnvironments)
+		if err != nil {
+			return errors.Wrap(err, "syncing auto-release labels")
+		}
+	}
+
+	if len(autoReleaseEnvironments) > 0 {
+		service.status, err = handler.retrieveStatus(ctx, serviceName)
+		if err != nil {
+			return err
+		}
+	}
+	messageData, botMessage, err := handler.renderBotMessage(ctx, client, repositoryOwner, repositoryName, event.GetPullRequest(), service, autoReleaseEnvironments)
+	if err != nil {
+		return err
+	}
+
+	// Send PR comment
+
 	// It's intentional that it's an IssueComment. The alternative PullRequestComment is a review comment
 	newComment := github.IssueComment{
 		Body: &botMessage,
@@ -143,17 +309,38 @@ func (handler *PRCreateHandler) Handle(ctx context.Context, eventType, deliveryI
 
 	logger.Info().Msgf("Comment created on %s PR %d", repositoryName, *event.PullRequest.Number)
 
+	// Request review from the owning squad
+	if handler.requestSquadReview && messageData.SquadTeam != "" {
+		team := squadTeam(messageData.Squad, handler.config(ctx).SquadToTeamMap)
+		_, _, err := client.PullRequests.RequestReviewers(ctx, repositoryOwner, repositoryName, prNum, github.ReviewersRequest{
+			TeamReviewers: []string{team},
+		})
+		if err != nil {
+			// The comment is already created so the delivery should not be retried
+			logger.Error().Msgf("Failed to request review from team '%s' on %s PR %d: %v", team, repositoryName, prNum, err)
+		} else {
+			logger.Info().Msgf("Review requested from team '%s' on %s PR %d", team, repositoryName, prNum)
+		}
+	}
+
 	return nil
 }
 
 func getServiceName(repoName string, mapping map[string]string) string {
+	serviceName, _ := resolveServiceName(repoName, mapping)
+	return serviceName
+}
+
+// resolveServiceName returns the service name of a repository and a
+// description of the rule it was resolved by.
+func resolveServiceName(repoName string, mapping map[string]string) (string, string) {
 	if mapping != nil {
 		serviceName, ok := mapping[repoName]
 		if ok {
-			return serviceName
+			return serviceName, fmt.Sprintf("mapped from repository '%s' by --map-repo-to-service", repoName)
 		}
 	}
-	return trimServiceName(repoName)
+	return trimServiceName(repoName), fmt.Sprintf("repository name '%s' without prefix 'lunar-way-' and suffix '-service'", repoName)
 }
 
 func trimServiceName(original string) string {
@@ -162,10 +349,11 @@ func trimServiceName(original string) string {
 	return serviceName
 }
 
-func retrieveFromReleaseManager(endpoint string, authToken string, output interface{}, logger zerolog.Logger, metricMiddleware http.RoundTripper) error {
+func retrieveFromReleaseManager(ctx context.Context, endpoint string, authToken string, output interface{}, metricMiddleware http.RoundTripper) error {
+	logger := zerolog.Ctx(ctx)
 	httpClient := &http.Client{Transport: metricMiddleware}
 
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return errors.Wrapf(err, "create GET request for release-manager endpoint '%s'", endpoint)
 	}
@@ -207,6 +395,53 @@ func retrieveFromReleaseManager(endpoint string, authToken string, output interf
 	return nil
 }
 
+// sendToReleaseManager sends input as JSON to a release-manager endpoint with
+// method and parses the response into output if it is not nil. Requests are
+// not retried as they are not guaranteed to be idempotent.
+func sendToReleaseManager(ctx context.Context, method string, endpoint string, authToken string, input interface{}, output interface{}, metricMiddleware http.RoundTripper) error {
+	logger := zerolog.Ctx(ctx)
+	httpClient := &http.Client{Transport: metricMiddleware}
+
+	requestBody, err := json.Marshal(input)
+	if err != nil {
+		return errors.Wrap(err, "encoding release-manager HTTP request body as json")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(requestBody))
+	if err != nil {
+		return errors.Wrapf(err, "create %s request for release-manager endpoint '%s'", method, endpoint)
+	}
+
+	req.Header.Add("Authorization", "Bearer "+authToken)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "sending HTTP request")
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "reading release-manager HTTP response body")
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Info().Msgf("Request body: %v", body)
+		return errors.Errorf("expected status code 2xx, but recieved %v", resp.StatusCode)
+	}
+
+	if output == nil || len(body) == 0 {
+		return nil
+	}
+	err = json.Unmarshal(body, output)
+	if err != nil {
+		return errors.Wrap(err, "parsing release-manager HTTP response body as json")
+	}
+
+	return nil
+}
+
 // Util
 func any(vs []string, f func(string) bool) bool {
 	for _, v := range vs {
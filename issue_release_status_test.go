@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testPrivateKeyOnce sync.Once
+	testPrivateKey     string
+)
+
+// newFakeHandler creates a PRCreateHandler using a FakeGithub and a
+// FakeReleaseManager with the fixtures.
+func newFakeHandler(t *testing.T, githubFixtures, releaseManagerFixtures fstest.MapFS) (*PRCreateHandler, *FakeGithub, *FakeReleaseManager) {
+	t.Helper()
+	testPrivateKeyOnce.Do(func() {
+		var err error
+		testPrivateKey, err = generatePrivateKey()
+		require.NoError(t, err)
+	})
+
+	fakeGithub := NewFakeGithub(githubFixtures, "")
+	githubServer := httptest.NewServer(fakeGithub)
+	t.Cleanup(githubServer.Close)
+	fakeReleaseManager := NewFakeReleaseManager(releaseManagerFixtures, "")
+	releaseManagerServer := httptest.NewServer(fakeReleaseManager)
+	t.Cleanup(releaseManagerServer.Close)
+
+	handler := &PRCreateHandler{
+		ClientCreator:                   githubapp.NewClientCreator(githubServer.URL+"/", githubServer.URL+"/graphql", 1, []byte(testPrivateKey)),
+		releaseManagerMetricsMiddleware: http.DefaultTransport,
+		releaseManagerURL:               releaseManagerServer.URL,
+		explainCommand:                  true,
+	}
+	handler.reloadable.Store(&ReloadableConfig{
+		MessageTemplate:         "'{{.Branch}}' will auto-release to:{{range .Releases}} {{.Environment}}{{end}}{{if .SquadTeam}}\n{{.SquadTeam}}{{end}}",
+		SensitiveEnvironments:   []string{"prod"},
+		SquadToTeamMap:          map[string]string{"aura": "aura"},
+		ReleaseManagerAuthToken: "token",
+	})
+	return handler, fakeGithub, fakeReleaseManager
+}
+
+var exampleReleaseManagerFixtures = fstest.MapFS{
+	"example/artifacts.json": {Data: []byte(`{"service":"example","artifacts":[{"id":"master-abc","squad":"aura"}]}`)},
+	"example/policies.json":  {Data: []byte(`{"service":"example","autoReleases":[{"branch":"master","environment":"dev"},{"branch":"master","environment":"prod"}]}`)},
+}
+
+func TestPRCreateHandler_pullRequest(t *testing.T) {
+	tt := []struct {
+		name      string
+		synthetic string
+		base      string
+		service   fstest.MapFS
+		comment   string
+	}{
+		{
+			name:      "opened auto-releasing",
+			synthetic: SyntheticEventOpened,
+			base:      "master",
+			service:   exampleReleaseManagerFixtures,
+			comment:   "'master' will auto-release to: dev prod\n@lunarway/aura\n" + commentMarker,
+		},
+		{
+			name:      "opened without auto-releases",
+			synthetic: SyntheticEventOpened,
+			base:      "feature",
+			service:   exampleReleaseManagerFixtures,
+			comment:   "'feature' will auto-release to:\n" + commentMarker,
+		},
+		{
+			name:      "base changed",
+			synthetic: SyntheticEventBaseChanged,
+			base:      "master",
+			service:   exampleReleaseManagerFixtures,
+			comment:   "'master' will auto-release to: dev prod\n@lunarway/aura\n" + commentMarker,
+		},
+		{
+			name:      "unmanaged service",
+			synthetic: SyntheticEventOpened,
+			base:      "master",
+			service:   fstest.MapFS{},
+		},
+		{
+			name:      "merged",
+			synthetic: SyntheticEventMerged,
+			base:      "master",
+			service:   exampleReleaseManagerFixtures,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, fakeGithub, fakeReleaseManager := newFakeHandler(t, fstest.MapFS{}, tc.service)
+			payload, err := replayOptions{
+				Synthetic:      tc.synthetic,
+				Repository:     "lunarway/example",
+				PullRequest:    1,
+				Base:           tc.base,
+				PreviousBase:   "develop",
+				Head:           "feature",
+				HeadSHA:        "abc",
+				InstallationID: 1,
+			}.syntheticPullRequestEvent()
+			require.NoError(t, err)
+
+			err = handler.Handle(zerolog.Nop().WithContext(context.Background()), "pull_request", "delivery", payload)
+
+			require.NoError(t, err)
+			comments := fakeGithub.Comments("lunarway", "example", 1)
+			if tc.comment == "" {
+				assert.Empty(t, comments, "comments")
+				assert.Empty(t, fakeGithub.Writes(), "github writes")
+			} else {
+				require.Len(t, comments, 1, "comments")
+				assert.Equal(t, tc.comment, comments[0].GetBody())
+			}
+			assert.Empty(t, fakeReleaseManager.Writes(), "release-manager writes")
+		})
+	}
+}
+
+func TestPRCreateHandler_explainCommand(t *testing.T) {
+	pullRequest, err := json.Marshal(github.PullRequest{
+		Number: github.Ptr(1),
+		Base:   &github.PullRequestBranch{Ref: github.Ptr("master")},
+	})
+	require.NoError(t, err)
+	handler, fakeGithub, _ := newFakeHandler(t, fstest.MapFS{
+		"repos/lunarway/example/pulls/1.json": {Data: pullRequest},
+	}, exampleReleaseManagerFixtures)
+
+	payload, err := json.Marshal(github.IssueCommentEvent{
+		Action: github.Ptr("created"),
+		Issue: &github.Issue{
+			Number:           github.Ptr(1),
+			PullRequestLinks: &github.PullRequestLinks{URL: github.Ptr("https://api.github.com/repos/lunarway/example/pulls/1")},
+		},
+		Comment: &github.IssueComment{
+			Body: github.Ptr(explainCommand),
+			User: &github.User{Login: github.Ptr("developer")},
+		},
+		Repo: &github.Repository{
+			Name:     github.Ptr("example"),
+			FullName: github.Ptr("lunarway/example"),
+			Owner:    &github.User{Login: github.Ptr("lunarway")},
+		},
+		Installation: &github.Installation{ID: github.Ptr(int64(1))},
+	})
+	require.NoError(t, err)
+
+	err = handler.Handle(zerolog.Nop().WithContext(context.Background()), "issue_comment", "delivery", payload)
+
+	require.NoError(t, err)
+	comments := fakeGithub.Comments("lunarway", "example", 1)
+	require.Len(t, comments, 1)
+	assert.True(t, strings.HasPrefix(comments[0].GetBody(), "@developer\n\n### Release-manager bot decisions"), comments[0].GetBody())
+	assert.Contains(t, comments[0].GetBody(), "Merging auto-releases to `dev`, `prod`.")
+}
+
+func TestSendToReleaseManager(t *testing.T) {
+	var authorization, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		content, _ := io.ReadAll(r.Body)
+		body = string(content)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.Write([]byte(`{"id":"hold"}`))
+	}))
+	defer server.Close()
+	ctx := zerolog.Nop().WithContext(context.Background())
+
+	var output ReleaseHoldResponse
+	err := sendToReleaseManager(ctx, http.MethodPost, server.URL+"/holds", "token", ReleaseHoldRequest{ID: "hold", Service: "example"}, &output, http.DefaultTransport)
+
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer token", authorization)
+	assert.JSONEq(t, `{"id":"hold","service":"example"}`, body)
+	assert.Equal(t, "hold", output.ID)
+
+	err = sendToReleaseManager(ctx, http.MethodDelete, server.URL+"/holds", "token", DeleteReleaseHoldRequest{ID: "hold"}, nil, http.DefaultTransport)
+	assert.NoError(t, err, "no output")
+
+	err = sendToReleaseManager(ctx, http.MethodPost, server.URL+"/fail", "token", ReleaseHoldRequest{}, &output, http.DefaultTransport)
+	assert.EqualError(t, err, "expected status code 2xx, but recieved 409")
+}
+
+func TestPRCreateHandler_config(t *testing.T) {
+	handler := &PRCreateHandler{}
+	handler.reloadable.Store(&ReloadableConfig{MessageTemplate: "initial"})
+	ctx := withConfig(context.Background(), handler.config(context.Background()))
+
+	handler.reloadable.Store(&ReloadableConfig{MessageTemplate: "reloaded"})
+
+	assert.Equal(t, "initial", handler.config(ctx).MessageTemplate, "delivery keeps its configuration")
+	assert.Equal(t, "reloaded", handler.config(context.Background()).MessageTemplate)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// autoReleaseLabelChanges returns the labels to add to and remove from a pull
+// request with the labels current so that its auto-release labels, i.e. the
+// ones starting with prefix, match environments.
+func autoReleaseLabelChanges(prefix string, current []string, environments []string) (add []string, remove []string) {
+	desired := make(map[string]bool)
+	for _, environment := range environments {
+		desired[prefix+environment] = true
+	}
+
+	existing := make(map[string]bool)
+	for _, label := range current {
+		existing[label] = true
+		if strings.HasPrefix(label, prefix) && !desired[label] {
+			remove = append(remove, label)
+		}
+	}
+	for _, environment := range environments {
+		label := prefix + environment
+		if !existing[label] {
+			add = append(add, label)
+			existing[label] = true
+		}
+	}
+	return add, remove
+}
+
+// syncAutoReleaseLabels makes the auto-release labels on a pull request match
+// the auto-release environments. Missing labels are created in the repository.
+// It reports whether any labels were changed.
+func (handler *PRCreateHandler) syncAutoReleaseLabels(ctx context.Context, client *github.Client, owner, repo string, prNum int, current []*github.Label, environments []string) (bool, error) {
+	logger := zerolog.Ctx(ctx)
+
+	var currentNames []string
+	for _, label := range current {
+		currentNames = append(currentNames, label.GetName())
+	}
+	add, remove := autoReleaseLabelChanges(handler.autoReleaseLabelPrefix, currentNames, environments)
+
+	for _, label := range remove {
+		_, err := client.Issues.RemoveLabelForIssue(ctx, owner, repo, prNum, label)
+		if err != nil {
+			return true, errors.Wrapf(err, "removing label '%s'", label)
+		}
+		logger.Info().Msgf("Label '%s' removed from %s PR %d", label, repo, prNum)
+	}
+
+	if len(add) == 0 {
+		return len(remove) > 0, nil
+	}
+	for _, label := range add {
+		err := handler.ensureLabel(ctx, client, owner, repo, label)
+		if err != nil {
+			return len(remove) > 0, err
+		}
+	}
+	_, _, err := client.Issues.AddLabelsToIssue(ctx, owner, repo, prNum, add)
+	if err != nil {
+		return len(remove) > 0, errors.Wrapf(err, "adding labels %v", add)
+	}
+	logger.Info().Msgf("Labels %v added to %s PR %d", add, repo, prNum)
+
+	return true, nil
+}
+
+// ensureLabel creates the label in the repository if it does not exist.
+func (handler *PRCreateHandler) ensureLabel(ctx context.Context, client *github.Client, owner, repo, name string) error {
+	_, resp, err := client.Issues.GetLabel(ctx, owner, repo, name)
+	if err == nil {
+		return nil
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		return errors.Wrapf(err, "getting label '%s'", name)
+	}
+
+	color := handler.autoReleaseLabelColor
+	if environmentColor, ok := handler.autoReleaseLabelColors[strings.TrimPrefix(name, handler.autoReleaseLabelPrefix)]; ok {
+		color = environmentColor
+	}
+	_, _, err = client.Issues.CreateLabel(ctx, owner, repo, &github.Label{
+		Name:  github.Ptr(name),
+		Color: github.Ptr(color),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "creating label '%s'", name)
+	}
+	zerolog.Ctx(ctx).Info().Msgf("Label '%s' created in %s", name, repo)
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoReleaseLabelChanges(t *testing.T) {
+	tt := []struct {
+		name           string
+		current        []string
+		environments   []string
+		expectedAdd    []string
+		expectedRemove []string
+	}{
+		{
+			name:         "new pull request",
+			current:      []string{"bug"},
+			environments: []string{"dev", "prod"},
+			expectedAdd:  []string{"auto-release:dev", "auto-release:prod"},
+		},
+		{
+			name:           "base changed to branch without auto-release",
+			current:        []string{"bug", "auto-release:dev", "auto-release:prod"},
+			environments:   nil,
+			expectedRemove: []string{"auto-release:dev", "auto-release:prod"},
+		},
+		{
+			name:           "base changed to other auto-release branch",
+			current:        []string{"auto-release:dev", "auto-release:prod"},
+			environments:   []string{"dev", "staging"},
+			expectedAdd:    []string{"auto-release:staging"},
+			expectedRemove: []string{"auto-release:prod"},
+		},
+		{
+			name:         "in sync",
+			current:      []string{"auto-release:dev"},
+			environments: []string{"dev"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			add, remove := autoReleaseLabelChanges("auto-release:", tc.current, tc.environments)
+
+			// Assert
+			assert.Equal(t, tc.expectedAdd, add)
+			assert.Equal(t, tc.expectedRemove, remove)
+		})
+	}
+}
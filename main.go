@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"maps"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gregjones/httpcache"
@@ -14,6 +19,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
 )
 
 func main() {
@@ -29,7 +35,7 @@ func main() {
 		Logger()
 
 	// Flags
-	releaseManagerAuthToken := pflag.String("release-manager-auth-token", "", "auth token for accessing release manager")
+	pflag.String("release-manager-auth-token", "", "auth token for accessing release manager")
 	releaseManagerURL := pflag.String("release-manager-url", "http://localhost:8080", "url to release manager; without '/' at the end")
 
 	var httpServerConfig baseapp.HTTPConfig
@@ -40,73 +46,358 @@ func main() {
 	var githubappConfig githubapp.Config
 	pflag.StringVar(&githubappConfig.V3APIURL, "github-v3-api-url", "https://api.github.com/", "github v3 api url")
 	pflag.Int64Var(&githubappConfig.App.IntegrationID, "github-integration-id", 0, "github App ID (App->General->About->App ID)")
-	pflag.StringVar(&githubappConfig.App.WebhookSecret, "github-webhook-secret", "", "github webhook secret")
-	pflag.StringVar(&githubappConfig.App.PrivateKey, "github-private-key", "", "github app private key content")
+	pflag.String("github-webhook-secret", "", "github webhook secret. Any of multiple secrets on separate lines is accepted, allowing the secret to be rotated")
+	pflag.String("github-private-key", "", "github app private key content")
 	githubWebhookRoute := pflag.String("github-webhook-route", "/webhook/github/bot", "route to listen for webhooks from Github")
 
-	messageTemplate := pflag.String("message-template", "'{{.Branch}}' will auto-release to: {{range .AutoReleaseEnvironments}}\n {{.}}{{end}}", "Template string used when commenting on pull requests on Github. The template format is golang templates [http://golang.org/pkg/text/template/#pkg-overview].")
+	pflag.String("message-template", "'{{.Branch}}' will auto-release to: {{range .Releases}}\n {{.Environment}}{{if .CompareURL}} ([{{.CommitCount}} commits]({{.CompareURL}}) since {{.DeployedArtifactID}}){{end}}{{end}}{{if .SquadTeam}}\n\n{{.SquadTeam}} this will auto-release to {{join .SensitiveEnvironments \", \"}}{{end}}{{if .OutsideDeploymentWindow}}\n\n:warning: Merging now auto-releases outside the deployment window of {{join .OutsideDeploymentWindow \", \"}}{{end}}", "Template string used when commenting on pull requests on Github. The template format is golang templates [http://golang.org/pkg/text/template/#pkg-overview].")
 	repoFilter := pflag.StringSlice("ignored-repositories", []string{}, "Slice with names of repositories which the bot should not respond to")
-	repoToServiceMap := pflag.StringToString("map-repo-to-service", map[string]string{}, "Map where key is repo name and value is assigned/interpreted service name. Ex. usage: '--map-repo-to-service=repo1=service1,repo2=service2'")
+	pflag.StringToString("map-repo-to-service", map[string]string{}, "Map where key is repo name and value is assigned/interpreted service name. Ex. usage: '--map-repo-to-service=repo1=service1,repo2=service2'")
+	releaseReadinessCheck := pflag.Bool("release-readiness-check", false, "Create a check run on pull request head commits reporting whether release-manager has built an artifact for the commit. Requires the 'checks' write permission")
+	releaseReadinessCheckName := pflag.String("release-readiness-check-name", "release-manager/artifact", "Name of the release readiness check run")
+	pflag.StringSlice("sensitive-environments", []string{}, "Slice with names of environments where auto-releases mention the owning squad's team. Only squads in --map-squad-to-team are mentioned")
+	pflag.StringToString("map-squad-to-team", map[string]string{}, "Map where key is squad name and value is the slug of the squad's Github team. Squads not in the map are not mentioned. Ex. usage: '--map-squad-to-team=squad1=team1,squad2=team2'")
+	requestSquadReview := pflag.Bool("request-squad-review", false, "Request review from the owning squad's team on pull requests auto-releasing to sensitive environments")
+	autoReleaseLabels := pflag.Bool("auto-release-labels", false, "Label pull requests with the environments they auto-release to")
+	autoReleaseLabelPrefix := pflag.String("auto-release-label-prefix", "auto-release:", "Prefix of auto-release labels. The environment name is appended to the prefix")
+	autoReleaseLabelColor := pflag.String("auto-release-label-color", "0e8a16", "Hex color without '#' used when creating auto-release labels")
+	autoReleaseLabelColors := pflag.StringToString("auto-release-label-colors", map[string]string{}, "Map where key is environment and value is the hex color used when creating its auto-release label. Ex. usage: '--auto-release-label-colors=dev=c2e0c6,prod=b60205'")
+	releaseHoldLabel := pflag.String("release-hold-label", "", "Label that holds auto-releases of merged pull requests in release-manager until it is removed. Holds are placed per pull request. Disabled if empty. Ex. 'hold-release'")
+	freezeStatus := pflag.Bool("freeze-status", false, "Set a commit status on pull requests that fails while any of their auto-release environments are locked or frozen in release-manager. The pull requests are re-evaluated when a lock with an end time expires. Requires the 'statuses' write permission")
+	freezeStatusContext := pflag.String("freeze-status-context", "release-manager/freeze", "Context of the freeze commit status")
+	pflag.String("message-template-file", "", "Path of a file with the template used when commenting on pull requests. Overrides the default of --message-template")
+	pflag.String("deployment-windows-file", "", "Path to a YAML file mapping environments to the weekdays, hours, time zone and holidays where auto-releases are allowed. Pull requests auto-releasing outside a window are warned")
+	deploymentWindowStatusEnabled := pflag.Bool("deployment-window-status", false, "Set a commit status on pull requests that fails while merging would auto-release outside a deployment window. Requires the 'statuses' write permission")
+	deploymentWindowStatusContext := pflag.String("deployment-window-status-context", "release-manager/deployment-window", "Context of the deployment window commit status")
+	reconcileInterval := pflag.Duration("reconcile-interval", 0, "Interval between reconciliations of comments, checks, statuses and labels on open pull requests, eg. 5m. Every open pull request of every repository is visited. Disabled if 0")
+	reconcileRepositoryDelay := pflag.Duration("reconcile-repository-delay", time.Second, "Delay between reconciling repositories")
+	reconcileMinRateRemaining := pflag.Int("reconcile-min-rate-remaining", 1000, "Pause reconciliation of an installation until its Github rate limit resets when fewer requests than this remain")
+	releaseManagerWebhookRoute := pflag.String("release-manager-webhook-route", "/webhook/release-manager", "route to listen for events from release-manager")
+	releaseManagerWebhookSecret := pflag.String("release-manager-webhook-secret", "", "bearer token release-manager authenticates events with. Events are not received if empty")
+	policyFilePath := pflag.String("policy-file-path", "", "Path of the file in repositories declaring release-manager policies. Pull requests into the default branch changing the file get a plan of the policy changes, which are applied when merged. Removing the file leaves the policies in release-manager unchanged. Disabled if empty. Ex. '.release-manager/policies.yaml'")
+	policyDriftInterval := pflag.Duration("policy-drift-interval", time.Hour, "Interval between comparisons of repository policy files with the policies in release-manager. Requires --policy-file-path. Disabled if 0")
+	policyDriftIssues := pflag.Bool("policy-drift-issues", false, "Open an issue in repositories whose policy file differs from the policies in release-manager and close it when resolved. Requires the 'issues' write permission")
+	policyDriftIssueLabel := pflag.String("policy-drift-issue-label", "release-manager-policy-drift", "Label identifying policy drift issues")
+	adminAuthToken := pflag.String("admin-auth-token", "", "bearer token authenticating requests to admin endpoints. Admin endpoints are disabled if empty")
+	policyDriftRoute := pflag.String("policy-drift-route", "/admin/policy-drift", "admin route listing the policy drift of repositories")
+	explainCommand := pflag.Bool("explain-command", false, "Reply to '/release-manager explain' comments on pull requests with why the bot did or did not comment. Requires subscribing to 'issue_comment' events")
+	deliveriesRoute := pflag.String("deliveries-route", "/admin/deliveries", "admin route listing the latest webhook deliveries and how they were handled")
+	deliveryLogSize := pflag.Int("delivery-log-size", 500, "Number of latest webhook deliveries kept for the deliveries admin route")
+	deliveryLogFile := pflag.String("delivery-log-file", "", "Path of a file persisting the latest webhook deliveries across restarts. Deliveries are only kept in memory if empty")
+	deliveryLogPersistInterval := pflag.Duration("delivery-log-persist-interval", 10*time.Second, "Interval between writes of changed deliveries to 'delivery-log-file'. Deliveries are also written on shutdown")
+	healthRoute := pflag.String("health-route", "/healthz", "route to expect liveness probes from")
+	readinessRoute := pflag.String("readiness-route", "/readyz", "route to expect readiness probes from. Ready when release-manager is reachable and authenticated and the Github app can list installations")
+	readinessCacheTTL := pflag.Duration("readiness-cache-ttl", 30*time.Second, "Duration the result of readiness checks is reused for")
+	failFastSelfCheck := pflag.Bool("fail-fast-self-check", false, "Exit at startup if release-manager or the Github app is misconfigured")
+	shutdownDelay := pflag.Duration("shutdown-delay", 5*time.Second, "Duration between failing readiness and closing the listener on SIGTERM or SIGINT, allowing load balancers to stop routing requests. Should exceed the period of readiness probes")
+	shutdownGracePeriod := pflag.Duration("shutdown-grace-period", 25*time.Second, "Maximum duration to wait for in-flight webhooks and background work to finish on shutdown")
+	tracingExporter := pflag.String("tracing-exporter", TracingExporterNone, "Exporter of OpenTelemetry traces of webhook deliveries and their release-manager and Github requests. One of 'none', 'otlp' or 'stdout'")
+	tracingOTLPEndpoint := pflag.String("tracing-otlp-endpoint", "", "URL of the OTLP HTTP endpoint traces are exported to. Defaults to the OTEL_EXPORTER_OTLP_ENDPOINT environment variable")
+	tracingSampleRatio := pflag.Float64("tracing-sample-ratio", 1, "Ratio of webhook deliveries traced")
+	configReloadInterval := pflag.Duration("config-reload-interval", 30*time.Second, "Interval between checks for changes of the configuration file, the directory of the message template file and the deployment windows file. Changes are reloaded without a restart, as on SIGHUP. Only the message template, deployment windows, sensitive environments, the repository to service and squad to team maps and secrets read from files are reloaded. Disabled if 0")
+	dryRun := pflag.Bool("dry-run", false, "Log Github and release-manager writes, like comments, labels, check runs, statuses and policy changes, instead of performing them. Reads are performed")
+	dryRunRoute := pflag.String("dry-run-route", "/admin/dry-run", "admin route listing the latest writes skipped in dry-run mode")
+	payloadArchiveDir := pflag.String("payload-archive-dir", "", "Path of a directory archiving the headers and payload of every Github webhook delivery with secrets redacted. Archived deliveries can be replayed with the 'replay' subcommand. Disabled if empty")
+	payloadArchiveMaxBytes := pflag.Int64("payload-archive-max-bytes", 100<<20, "Maximum total size in bytes of archived deliveries. The oldest deliveries are removed first. Unlimited if 0")
+	payloadArchiveMaxAge := pflag.Duration("payload-archive-max-age", 7*24*time.Hour, "Maximum age of archived deliveries, checked when deliveries are archived. Unlimited if 0")
+	simulate := pflag.String("simulate", "", "Path of a directory with fixtures of Github and release-manager responses to run against instead of the live services. Writes and comments are recorded in a new directory of its 'output' directory on every start. No credentials are needed")
 	metricsRoute := pflag.String("metrics-route", "/metrics", "route to expect prometheus requests from")
 
-	pflag.Parse()
+	addConfigFlags(pflag.CommandLine)
 
-	// Flag validation
-	if *releaseManagerAuthToken == "" {
-		logger.Error().Msg("flag 'release-manager-auth-token' is empty")
+	subcommand, args, err := parseSubcommand(os.Args[1:])
+	if err != nil {
+		logger.Error().Msgf("%v", err)
+		os.Exit(1)
+		return
+	}
+	var replayOpts *replayOptions
+	if subcommand == SubcommandReplay {
+		replayOpts = addReplayFlags(pflag.CommandLine)
+	}
+	err = loadConfig(pflag.CommandLine, args, os.LookupEnv, os.ReadFile)
+	if err != nil {
+		logger.Error().Msgf("Failed to load configuration: %v", err)
 		os.Exit(1)
 		return
 	}
 
-	// Template validation, fail fast
-	_, err := BotMessage(BotMessageData{
-		Template:                *messageTemplate,
-		Branch:                  "master",
-		AutoReleaseEnvironments: []string{"dev", "prod"},
-	})
+	// Replay webhooks signed with the current secret
+	var replayPayloads []replayPayload
+	var replaySecret string
+	if subcommand == SubcommandReplay {
+		replayPayloads, err = replayOpts.payloads(pflag.CommandLine.Args())
+		if err != nil {
+			logger.Error().Msgf("Failed to read replay payloads: %v", err)
+			os.Exit(1)
+			return
+		}
+		webhookSecret, _ := pflag.CommandLine.GetString("github-webhook-secret")
+		if secrets := splitSecrets(webhookSecret); len(secrets) > 0 {
+			replaySecret = secrets[0]
+		}
+		if replayOpts.URL == "" {
+			replayOpts.URL = "http://localhost:" + strconv.Itoa(httpServerConfig.Port) + *githubWebhookRoute
+		}
+		if !replayOpts.InProcess {
+			err = replay(logger.WithContext(context.Background()), replayOpts.URL, replaySecret, replayPayloads, http.DefaultClient.Do)
+			if err != nil {
+				logger.Error().Msgf("Failed to replay: %v", err)
+				os.Exit(1)
+				return
+			}
+			os.Exit(0)
+			return
+		}
+	}
+
+	// Simulation with fixtures instead of Github and release-manager
+	var sim *simulation
+	if *simulate != "" {
+		sim, err = startSimulation(*simulate)
+		if err != nil {
+			logger.Error().Msgf("flag 'simulate' error recieved: %v", err)
+			os.Exit(1)
+			return
+		}
+		err = sim.configure(pflag.CommandLine)
+		if err != nil {
+			logger.Error().Msgf("flag 'simulate' error recieved: %v", err)
+			os.Exit(1)
+			return
+		}
+		logger.Info().Msgf("Simulating Github and release-manager with the fixtures in '%s'. Writes and comments are recorded in '%s'", *simulate, sim.Output)
+	}
+
+	// Secrets, template and deployment windows validation, fail fast
+	reloadableConfig, err := reloadableConfigFromFlags(pflag.CommandLine, os.ReadFile)
 	if err != nil {
-		logger.Error().Msgf("flag 'message-template' parsing error recieved: %v", err)
+		logger.Error().Msgf("%v", err)
 		os.Exit(1)
 		return
 	}
 
+	// Tracing
+	shutdownTracing, err := setupTracing(context.Background(), *tracingExporter, *tracingOTLPEndpoint, *tracingSampleRatio)
+	if err != nil {
+		logger.Error().Msgf("flag 'tracing-exporter' error recieved: %v", err)
+		os.Exit(1)
+		return
+	}
+	if subcommand == SubcommandValidateConfig {
+		_ = shutdownTracing(context.Background())
+		logger.Info().Msg("Configuration is valid")
+		os.Exit(0)
+		return
+	}
+	tracer := otel.Tracer(tracerName)
+
 	// Metrics
 	prometheusRegistry := prometheus.DefaultRegisterer
 
-	// Create Github client
-	cc, err := githubapp.NewDefaultCachingClientCreator(
-		githubappConfig,
+	// Dry-run
+	var dryRunLog *DryRunLog
+	if *dryRun {
+		dryRunLog = NewDryRunLog(dryRunLogSize)
+		logger.Info().Msg("Dry-run enabled; Github and release-manager writes are logged instead of performed")
+	}
+
+	// Create Github client. It is recreated when the private key is rotated.
+	githubClientOptions := []githubapp.ClientOption{
 		githubapp.WithClientUserAgent("release-managar-bot/1.0.0"),
-		githubapp.WithClientTimeout(3*time.Second),
+		githubapp.WithClientTimeout(3 * time.Second),
 		githubapp.WithClientCaching(false, func() httpcache.Cache { return httpcache.NewMemoryCache() }),
 		githubapp.WithClientMiddleware(
 			githubapp.ClientLogging(zerolog.DebugLevel),
 			clientMetricsMiddleware(prometheusRegistry, "github"),
+			deliveryCallsMiddleware("github"),
+			tracingMiddleware(tracer, "github"),
+			dryRunMiddleware("github", dryRunLog),
 			githubMetricsMiddleware(prometheusRegistry),
 		),
-	)
+	}
+	cc, err := newReloadingClientCreator(reloadableConfig.GithubPrivateKey, func(privateKey string) (githubapp.ClientCreator, error) {
+		config := githubappConfig
+		config.App.PrivateKey = privateKey
+		return githubapp.NewDefaultCachingClientCreator(config, githubClientOptions...)
+	})
 	if err != nil {
 		logger.Error().Msgf("Failed to instatiate Github client: %v", err)
 		os.Exit(1)
 		return
 	}
 
+	// Stop on SIGTERM or SIGINT
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	workers := &workerGroup{}
+
+	// Delivery log
+	deliveryLog, err := NewDeliveryLog(*deliveryLogSize, *deliveryLogFile)
+	if err != nil {
+		logger.Error().Msgf("flag 'delivery-log-file' error recieved: %v", err)
+		os.Exit(1)
+		return
+	}
+	if *deliveryLogFile != "" {
+		if *deliveryLogPersistInterval <= 0 {
+			logger.Error().Msg("flag 'delivery-log-persist-interval' must be positive")
+			os.Exit(1)
+			return
+		}
+		workers.Go(func() { deliveryLog.Run(ctx, *deliveryLogPersistInterval, logger) })
+	}
+
 	pullRequestHandler := &PRCreateHandler{
 		ClientCreator:                   cc,
-		releaseManagerMetricsMiddleware: clientMetricsMiddleware(prometheusRegistry, "release-manager")(http.DefaultTransport),
-		releaseManagerAuthToken:         *releaseManagerAuthToken,
+		releaseManagerMetricsMiddleware: deliveryCallsMiddleware("release-manager")(tracingMiddleware(tracer, "release-manager")(dryRunMiddleware("release-manager", dryRunLog)(clientMetricsMiddleware(prometheusRegistry, "release-manager")(http.DefaultTransport)))),
 		releaseManagerURL:               *releaseManagerURL,
-		messageTemplate:                 *messageTemplate,
 		repoFilters:                     *repoFilter,
-		repoToServiceMap:                *repoToServiceMap,
+		releaseReadinessCheck:           *releaseReadinessCheck,
+		releaseReadinessCheckName:       *releaseReadinessCheckName,
+		requestSquadReview:              *requestSquadReview,
+		autoReleaseLabels:               *autoReleaseLabels,
+		autoReleaseLabelPrefix:          *autoReleaseLabelPrefix,
+		autoReleaseLabelColor:           *autoReleaseLabelColor,
+		autoReleaseLabelColors:          *autoReleaseLabelColors,
+		releaseHoldLabel:                *releaseHoldLabel,
+		freezeStatus:                    *freezeStatus,
+		freezeStatusContext:             *freezeStatusContext,
+		deploymentWindowStatus:          *deploymentWindowStatusEnabled,
+		deploymentWindowStatusContext:   *deploymentWindowStatusContext,
+		policyFilePath:                  *policyFilePath,
+		explainCommand:                  *explainCommand,
+		deliveries:                      deliveryLog,
 	}
+	pullRequestHandler.reloadable.Store(reloadableConfig)
 
-	webhookHandler := githubapp.NewDefaultEventDispatcher(githubappConfig, pullRequestHandler)
+	// Readiness checks
+	readiness := &readinessChecker{
+		checks: []dependencyCheck{
+			// release-manager authenticates the request before looking up the service
+			{name: "release-manager", check: releaseManagerCheck(*releaseManagerURL+"/policies?service=release-manager-bot", func() string { return pullRequestHandler.reloadable.Load().ReleaseManagerAuthToken }, http.DefaultTransport)},
+			{name: "github", check: githubAppCheck(cc)},
+		},
+		cacheTTL: *readinessCacheTTL,
+		timeout:  10 * time.Second,
+	}
+	if *failFastSelfCheck {
+		response := readiness.Check(ctx)
+		if !response.Ready {
+			logger.Error().Msgf("flag 'fail-fast-self-check' self-check failed: %v", response.Checks)
+			os.Exit(1)
+			return
+		}
+		logger.Info().Msg("Self-check succeeded")
+	}
+
+	// Reconciliation
+	reconciler := NewReconciler(cc, *reconcileInterval, *reconcileRepositoryDelay, *reconcileMinRateRemaining, *repoFilter, pullRequestHandler.serviceName, pullRequestHandler.reconcilePullRequests, logger, prometheusRegistry)
+	pullRequestHandler.reconcileServiceAt = func(service string, at time.Time) {
+		reconciler.ReconcileServiceAt(ctx, workers, service, at)
+	}
+	if *reconcileInterval > 0 {
+		workers.Go(func() { reconciler.Run(ctx) })
+	}
+
+	// Configuration reloading
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	configFile, _ := pflag.CommandLine.GetString(configFlag)
+	templateFile, _ := pflag.CommandLine.GetString("message-template-file")
+	deploymentWindowsFile, _ := pflag.CommandLine.GetString("deployment-windows-file")
+	watchedFiles := []string{configFile, deploymentWindowsFile}
+	if templateFile != "" {
+		// The template is watched with the directory it is mounted in
+		watchedFiles = append(watchedFiles, filepath.Dir(templateFile))
+	}
+	for _, name := range []string{"github-private-key", "github-webhook-secret", "release-manager-auth-token"} {
+		secretFile, _ := pflag.CommandLine.GetString(name + "-file")
+		watchedFiles = append(watchedFiles, secretFile)
+	}
+	configReloader := NewConfigReloader(func() (*ReloadableConfig, error) {
+		flags := reloadFlagSet(pflag.CommandLine)
+		err := loadConfig(flags, args, os.LookupEnv, os.ReadFile)
+		if err != nil {
+			return nil, err
+		}
+		if sim != nil {
+			err = sim.configure(flags)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return reloadableConfigFromFlags(flags, os.ReadFile)
+	}, func(config *ReloadableConfig) {
+		for _, name := range changedSecrets(pullRequestHandler.reloadable.Load(), config) {
+			logger.Info().Msgf("Secret '%s' reloaded", name)
+		}
+		err := cc.SetPrivateKey(config.GithubPrivateKey)
+		if err != nil {
+			logger.Error().Msgf("Failed to recreate Github client with reloaded private key; keeping the previous key: %v", err)
+			config.GithubPrivateKey = pullRequestHandler.reloadable.Load().GithubPrivateKey
+		}
+		previous := pullRequestHandler.reloadable.Load()
+		pullRequestHandler.reloadable.Store(config)
+		if !maps.Equal(previous.RepoToServiceMap, config.RepoToServiceMap) {
+			reconciler.InvalidateServices()
+		}
+	}, watchedFiles, *configReloadInterval, logger, prometheusRegistry)
+	workers.Go(func() { configReloader.Run(ctx, sighup) })
+
+	// Policy drift detection
+	var policyDriftDetector *PolicyDriftDetector
+	if *policyFilePath != "" && *policyDriftInterval > 0 {
+		policyDriftDetector = NewPolicyDriftDetector(cc, *policyDriftInterval, *policyFilePath, *policyDriftIssues, *policyDriftIssueLabel, *repoFilter, pullRequestHandler.serviceName, pullRequestHandler.retrievePolicies, logger, prometheusRegistry)
+		workers.Go(func() { policyDriftDetector.Run(ctx) })
+	}
+
+	// Webhook signatures are verified by webhookSignatureMiddleware to accept
+	// any of the current secrets
+	dispatcherConfig := githubappConfig
+	dispatcherConfig.App.WebhookSecret = ""
+	webhookHandler := githubapp.NewDefaultEventDispatcher(dispatcherConfig, &tracingEventHandler{
+		EventHandler: &recordingEventHandler{
+			EventHandler: pullRequestHandler,
+			log:          deliveryLog,
+		},
+		tracer: tracer,
+	})
+
+	// Payload archive
+	if *payloadArchiveDir != "" {
+		archive, err := NewPayloadArchive(*payloadArchiveDir, *payloadArchiveMaxBytes, *payloadArchiveMaxAge, func() []string {
+			config := pullRequestHandler.reloadable.Load()
+			return append([]string{config.ReleaseManagerAuthToken}, config.GithubWebhookSecrets...)
+		})
+		if err != nil {
+			logger.Error().Msgf("flag 'payload-archive-dir' error recieved: %v", err)
+			os.Exit(1)
+			return
+		}
+		webhookHandler = payloadArchiveMiddleware(archive, webhookHandler)
+	}
 
 	// Create http server
 	mux := http.NewServeMux()
-	mux.Handle(*githubWebhookRoute, inboundMetricsMiddleware(prometheusRegistry, webhookHandler))
+	mux.Handle(*githubWebhookRoute, inboundMetricsMiddleware(prometheusRegistry, webhookSignatureMiddleware(func() []string {
+		return pullRequestHandler.reloadable.Load().GithubWebhookSecrets
+	}, webhookHandler)))
 	mux.Handle(*metricsRoute, promhttp.Handler())
+	mux.Handle(*healthRoute, livenessHandler())
+	mux.Handle(*readinessRoute, readiness)
+	if *releaseManagerWebhookSecret != "" {
+		mux.Handle(*releaseManagerWebhookRoute, bearerAuthMiddleware(*releaseManagerWebhookSecret, releaseManagerEventsHandler(ctx, workers, reconciler.ReconcileService)))
+	}
+	if *adminAuthToken != "" {
+		mux.Handle(*deliveriesRoute, bearerAuthMiddleware(*adminAuthToken, deliveryLog))
+		if dryRunLog != nil {
+			mux.Handle(*dryRunRoute, bearerAuthMiddleware(*adminAuthToken, dryRunLog))
+		}
+		if policyDriftDetector != nil {
+			mux.Handle(*policyDriftRoute, bearerAuthMiddleware(*adminAuthToken, policyDriftDetector))
+		}
+	}
 
 	// Middleware
 	httpHandler := loggerMiddleware(func(msg string, m map[string]interface{}) {
@@ -119,10 +410,61 @@ func main() {
 		Handler: httpHandler,
 	}
 
-	// Serve
-	err = s.ListenAndServe()
+	// Serve, or replay webhooks in-process
+	exitCode := 0
+	if subcommand == SubcommandReplay {
+		err = replay(logger.WithContext(ctx), replayOpts.URL, replaySecret, replayPayloads, inProcess(httpHandler))
+		if err != nil {
+			logger.Error().Msgf("Failed to replay: %v", err)
+			exitCode = 1
+		}
+	} else {
+		serveErr := make(chan error, 1)
+		go func() {
+			serveErr <- s.ListenAndServe()
+		}()
+		select {
+		case err := <-serveErr:
+			logger.Error().Msgf("Failed to serve: %v", err)
+			os.Exit(1)
+		case <-ctx.Done():
+		}
+	}
+
+	// Graceful shutdown
+	stop()
+	logger.Info().Msgf("Shutting down; waiting up to %s for in-flight webhooks and background work", *shutdownGracePeriod)
+	readiness.Shutdown()
+	if subcommand != SubcommandReplay {
+		time.Sleep(*shutdownDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownGracePeriod)
+	err = s.Shutdown(shutdownCtx)
 	if err != nil {
-		logger.Error().Msgf("Failed to serve: %v", err)
-		os.Exit(1)
+		logger.Error().Msgf("Failed to drain in-flight requests: %v", err)
+		exitCode = 1
+	}
+	err = workers.Wait(shutdownCtx)
+	if err != nil {
+		logger.Error().Msgf("Failed to wait for background work: %v", err)
+		exitCode = 1
+	}
+	err = deliveryLog.Flush()
+	if err != nil {
+		logger.Error().Msgf("Failed to persist delivery log: %v", err)
+		exitCode = 1
+	}
+	if sim != nil {
+		sim.Close()
+	}
+	err = shutdownTracing(shutdownCtx)
+	if err != nil {
+		logger.Error().Msgf("Failed to flush traces: %v", err)
+		exitCode = 1
 	}
+	cancel()
+	logger.Info().Msg("Shutdown completed")
+	_ = os.Stdout.Sync()
+	os.Exit(exitCode)
 }
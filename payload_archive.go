@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// redacted replaces secrets in archived deliveries.
+const redacted = "[REDACTED]"
+
+// redactedHeaders are headers never archived in clear text.
+var redactedHeaders = []string{
+	"Authorization",
+	"Cookie",
+	github.SHA1SignatureHeader,
+	github.SHA256SignatureHeader,
+}
+
+// redactedKeys matches payload keys whose string values are redacted.
+var redactedKeys = regexp.MustCompile(`(?i)secret|token|password`)
+
+// archiveFileName matches the names of archived deliveries.
+var archiveFileName = regexp.MustCompile(`^\d{8}T\d{9}Z-[A-Za-z0-9-]+\.json$`)
+
+// archiveDeliveryIDUnsafe matches characters of delivery IDs left out of
+// archive file names.
+var archiveDeliveryIDUnsafe = regexp.MustCompile(`[^A-Za-z0-9-]`)
+
+// ArchivedDelivery is a webhook delivery as it was received.
+type ArchivedDelivery struct {
+	DeliveryID string          `json:"deliveryId"`
+	Event      string          `json:"event"`
+	ReceivedAt time.Time       `json:"receivedAt"`
+	Headers    http.Header     `json:"headers"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+type archivedFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// PayloadArchive writes deliveries to files in a directory. The oldest files
+// are removed when they are older than maxAge or the files exceed maxBytes in
+// total. Limits are disabled if 0.
+type PayloadArchive struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	secrets  func() []string
+
+	mu    sync.Mutex
+	files []archivedFile
+	size  int64
+}
+
+// NewPayloadArchive creates a PayloadArchive in dir, creating it if needed.
+// Values of secrets are redacted from archived payloads.
+func NewPayloadArchive(dir string, maxBytes int64, maxAge time.Duration, secrets func() []string) (*PayloadArchive, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating archive directory")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading archive directory")
+	}
+
+	a := &PayloadArchive{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		secrets:  secrets,
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !archiveFileName.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading archived delivery '%s'", entry.Name())
+		}
+		a.files = append(a.files, archivedFile{name: entry.Name(), size: info.Size(), modTime: info.ModTime()})
+		a.size += info.Size()
+	}
+	sort.Slice(a.files, func(i, j int) bool {
+		return a.files[i].name < a.files[j].name
+	})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err = a.prune(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Add archives a delivery with secrets redacted and removes the oldest
+// deliveries exceeding the limits.
+func (a *PayloadArchive) Add(delivery ArchivedDelivery) error {
+	delivery.Headers = redactHeaders(delivery.Headers)
+	delivery.Payload = redactPayload(delivery.Payload, a.secrets())
+	content, err := json.MarshalIndent(delivery, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding delivery")
+	}
+
+	deliveryID := archiveDeliveryIDUnsafe.ReplaceAllString(delivery.DeliveryID, "")
+	if deliveryID == "" {
+		deliveryID = "unknown"
+	}
+	name := delivery.ReceivedAt.UTC().Format("20060102T150405.000Z")
+	name = strings.Replace(name, ".", "", 1) + "-" + deliveryID + ".json"
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err = os.WriteFile(filepath.Join(a.dir, name), content, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "writing delivery")
+	}
+	a.files = append(a.files, archivedFile{name: name, size: int64(len(content)), modTime: time.Now()})
+	a.size += int64(len(content))
+	return a.prune(time.Now())
+}
+
+// prune removes the oldest files until the archive is within its limits.
+func (a *PayloadArchive) prune(now time.Time) error {
+	for len(a.files) > 0 {
+		oldest := a.files[0]
+		tooOld := a.maxAge > 0 && now.Sub(oldest.modTime) > a.maxAge
+		tooLarge := a.maxBytes > 0 && a.size > a.maxBytes
+		if !tooOld && !tooLarge {
+			return nil
+		}
+		err := os.Remove(filepath.Join(a.dir, oldest.name))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "removing archived delivery '%s'", oldest.name)
+		}
+		a.files = a.files[1:]
+		a.size -= oldest.size
+	}
+	return nil
+}
+
+func redactHeaders(headers http.Header) http.Header {
+	headers = headers.Clone()
+	for _, name := range redactedHeaders {
+		if headers.Get(name) != "" {
+			headers.Set(name, redacted)
+		}
+	}
+	return headers
+}
+
+// redactPayload replaces the values of secrets and of keys looking like
+// secrets in payload. The payload is only re-encoded if a key is redacted.
+func redactPayload(payload []byte, secrets []string) []byte {
+	for _, secret := range secrets {
+		if secret != "" {
+			payload = bytes.ReplaceAll(payload, []byte(secret), []byte(redacted))
+		}
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if decoder.Decode(&value) != nil || !redactKeys(value) {
+		return payload
+	}
+	redactedPayload, err := json.Marshal(value)
+	if err != nil {
+		return payload
+	}
+	return redactedPayload
+}
+
+// redactKeys redacts string values of keys looking like secrets in value and
+// reports whether any were redacted.
+func redactKeys(value interface{}) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if s, ok := item.(string); ok && s != "" && redactedKeys.MatchString(key) {
+				v[key] = redacted
+				changed = true
+				continue
+			}
+			changed = redactKeys(item) || changed
+		}
+	case []interface{}:
+		for _, item := range v {
+			changed = redactKeys(item) || changed
+		}
+	}
+	return changed
+}
+
+// payloadArchiveMiddleware archives Github webhooks before passing them on to
+// h. Failing to archive a delivery does not fail it.
+func payloadArchiveMiddleware(archive *PayloadArchive, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := zerolog.Ctx(r.Context())
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Info().Msgf("Failed to read webhook body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Form encoded webhooks carry the JSON payload in the 'payload' field
+		payload := body
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			form, err := url.ParseQuery(string(body))
+			if err == nil {
+				payload = []byte(form.Get("payload"))
+			}
+		}
+		if !json.Valid(payload) {
+			payload, _ = json.Marshal(string(payload))
+		}
+
+		delivery := ArchivedDelivery{
+			DeliveryID: github.DeliveryID(r),
+			Event:      github.WebHookType(r),
+			ReceivedAt: time.Now(),
+			Headers:    r.Header,
+			Payload:    payload,
+		}
+		err = archive.Add(delivery)
+		if err != nil {
+			logger.Error().Msgf("Failed to archive delivery '%s': %v", delivery.DeliveryID, err)
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func archivedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestRedactPayload(t *testing.T) {
+	tt := []struct {
+		name    string
+		payload string
+		secrets []string
+		output  string
+	}{
+		{
+			name:    "nothing to redact",
+			payload: `{"action":"opened","number":1}`,
+			output:  `{"action":"opened","number":1}`,
+		},
+		{
+			name:    "secret values",
+			payload: `{"comment":{"body":"token is s3cr3t"}}`,
+			secrets: []string{"", "s3cr3t"},
+			output:  `{"comment":{"body":"token is [REDACTED]"}}`,
+		},
+		{
+			name:    "secret keys",
+			payload: `{"hook":{"config":{"secret":"value","url":"https://example.com"}},"items":[{"access_token":"value"}],"Password":"","number":12345678901234567890}`,
+			output:  `{"Password":"","hook":{"config":{"secret":"[REDACTED]","url":"https://example.com"}},"items":[{"access_token":"[REDACTED]"}],"number":12345678901234567890}`,
+		},
+		{
+			name:    "invalid json",
+			payload: `payload=s3cr3t`,
+			secrets: []string{"s3cr3t"},
+			output:  `payload=[REDACTED]`,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			output := redactPayload([]byte(tc.payload), tc.secrets)
+
+			assert.Equal(t, tc.output, string(output))
+		})
+	}
+}
+
+func TestPayloadArchive_Add(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "archive")
+	archive, err := NewPayloadArchive(dir, 0, 0, func() []string { return []string{"s3cr3t"} })
+	require.NoError(t, err)
+
+	headers := http.Header{}
+	headers.Set("X-GitHub-Event", "pull_request")
+	headers.Set(github.SHA256SignatureHeader, "sha256=abc")
+	headers.Set("Authorization", "Bearer s3cr3t")
+	receivedAt := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	err = archive.Add(ArchivedDelivery{
+		DeliveryID: "72d3162e-cc78-11e3-81ab-4c9367dc0958/..",
+		Event:      "pull_request",
+		ReceivedAt: receivedAt,
+		Headers:    headers,
+		Payload:    json.RawMessage(`{"action":"opened","body":"s3cr3t"}`),
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"20240102T030405006Z-72d3162e-cc78-11e3-81ab-4c9367dc0958.json"}, archivedFiles(t, dir))
+	content, err := os.ReadFile(filepath.Join(dir, "20240102T030405006Z-72d3162e-cc78-11e3-81ab-4c9367dc0958.json"))
+	require.NoError(t, err)
+	var delivery ArchivedDelivery
+	require.NoError(t, json.Unmarshal(content, &delivery))
+	assert.Equal(t, "pull_request", delivery.Event)
+	assert.Equal(t, receivedAt, delivery.ReceivedAt)
+	assert.Equal(t, "pull_request", delivery.Headers.Get("X-GitHub-Event"))
+	assert.Equal(t, redacted, delivery.Headers.Get(github.SHA256SignatureHeader))
+	assert.Equal(t, redacted, delivery.Headers.Get("Authorization"))
+	assert.JSONEq(t, `{"action":"opened","body":"[REDACTED]"}`, string(delivery.Payload))
+	assert.Equal(t, "sha256=abc", headers.Get(github.SHA256SignatureHeader), "request headers untouched")
+}
+
+func TestPayloadArchive_prune(t *testing.T) {
+	payload := json.RawMessage(`{"action":"opened"}`)
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("max bytes", func(t *testing.T) {
+		dir := t.TempDir()
+		archive, err := NewPayloadArchive(dir, 1, 0, func() []string { return nil })
+		require.NoError(t, err)
+		content, err := json.MarshalIndent(ArchivedDelivery{DeliveryID: "1", ReceivedAt: start, Headers: http.Header{}, Payload: payload}, "", "  ")
+		require.NoError(t, err)
+		archive.maxBytes = int64(2 * len(content))
+
+		for i, id := range []string{"1", "2", "3"} {
+			err := archive.Add(ArchivedDelivery{DeliveryID: id, ReceivedAt: start.Add(time.Duration(i) * time.Second), Headers: http.Header{}, Payload: payload})
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, []string{"20240102T030406000Z-2.json", "20240102T030407000Z-3.json"}, archivedFiles(t, dir))
+	})
+
+	t.Run("max age on startup", func(t *testing.T) {
+		dir := t.TempDir()
+		old := filepath.Join(dir, "20240102T030405000Z-1.json")
+		recent := filepath.Join(dir, "20240102T030406000Z-2.json")
+		other := filepath.Join(dir, "notes.txt")
+		for _, file := range []string{old, recent, other} {
+			require.NoError(t, os.WriteFile(file, []byte("{}"), 0o600))
+		}
+		require.NoError(t, os.Chtimes(old, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour)))
+		require.NoError(t, os.Chtimes(other, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour)))
+
+		archive, err := NewPayloadArchive(dir, 0, time.Hour, func() []string { return nil })
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"20240102T030406000Z-2.json", "notes.txt"}, archivedFiles(t, dir))
+		assert.Len(t, archive.files, 1)
+		assert.Equal(t, int64(2), archive.size)
+	})
+}
+
+func TestPayloadArchiveMiddleware(t *testing.T) {
+	tt := []struct {
+		name        string
+		contentType string
+		body        string
+		payload     string
+	}{
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `{"action":"opened"}`,
+			payload:     `{"action":"opened"}`,
+		},
+		{
+			name:        "form encoded",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"payload": []string{`{"action":"opened"}`}}.Encode(),
+			payload:     `{"action":"opened"}`,
+		},
+		{
+			name:        "not json",
+			contentType: "text/plain",
+			body:        `opened`,
+			payload:     `"opened"`,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive, err := NewPayloadArchive(dir, 0, 0, func() []string { return nil })
+			require.NoError(t, err)
+			var received string
+			handler := payloadArchiveMiddleware(archive, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				received = string(body)
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set("X-GitHub-Event", "pull_request")
+			req.Header.Set("X-GitHub-Delivery", "delivery")
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.body, received, "body passed on")
+			files := archivedFiles(t, dir)
+			require.Len(t, files, 1)
+			assert.True(t, strings.HasSuffix(files[0], "-delivery.json"), files[0])
+			content, err := os.ReadFile(filepath.Join(dir, files[0]))
+			require.NoError(t, err)
+			var delivery ArchivedDelivery
+			require.NoError(t, json.Unmarshal(content, &delivery))
+			assert.Equal(t, "delivery", delivery.DeliveryID)
+			assert.Equal(t, "pull_request", delivery.Event)
+			assert.JSONEq(t, tc.payload, string(delivery.Payload))
+		})
+	}
+}
+
+func TestReplayOptions_payloads_archived(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewPayloadArchive(dir, 0, 0, func() []string { return nil })
+	require.NoError(t, err)
+	err = archive.Add(ArchivedDelivery{
+		DeliveryID: "delivery",
+		Event:      "check_run",
+		ReceivedAt: time.Now(),
+		Headers:    http.Header{},
+		Payload:    json.RawMessage(`{"pull_request":{}}`),
+	})
+	require.NoError(t, err)
+
+	payloads, err := replayOptions{}.payloads([]string{dir})
+
+	require.NoError(t, err)
+	require.Len(t, payloads, 1)
+	assert.Equal(t, "check_run", payloads[0].EventType)
+	assert.Equal(t, "delivery", payloads[0].DeliveryID)
+	assert.JSONEq(t, `{"pull_request":{}}`, string(payloads[0].Body))
+
+	req, err := newReplayRequest(context.Background(), "http://localhost/webhook", "", payloads[0])
+	require.NoError(t, err)
+	assert.Equal(t, "delivery", req.Header.Get("X-GitHub-Delivery"))
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+// policyDriftMarker is added to drift issues so they can be updated and closed.
+const policyDriftMarker = "<!-- release-manager-bot:policy-drift -->"
+
+// PolicyDrift is the difference between the policy file of a repository and
+// the policies in release-manager. Plan is what applying the policy file would
+// change.
+type PolicyDrift struct {
+	Repository string     `json:"repository"`
+	Service    string     `json:"service"`
+	CheckedAt  time.Time  `json:"checkedAt"`
+	Plan       PolicyPlan `json:"plan"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// PolicyDriftDetector periodically compares the policy files of all
+// repositories with release-manager. Differences are reported as a metric, on
+// an admin endpoint and optionally as an issue in the repository.
+type PolicyDriftDetector struct {
+	githubapp.ClientCreator
+
+	interval       time.Duration
+	policyFilePath string
+	issues         bool
+	issueLabel     string
+	repoFilters    []string
+	serviceName    func(repoName string) string
+	policies       func(ctx context.Context, serviceName string) (ListPoliciesResponse, error)
+	logger         zerolog.Logger
+	metricDrift    *prometheus.GaugeVec
+
+	mu     sync.RWMutex
+	drifts map[string]PolicyDrift
+}
+
+func NewPolicyDriftDetector(cc githubapp.ClientCreator, interval time.Duration, policyFilePath string, issues bool, issueLabel string, repoFilters []string, serviceName func(repoName string) string, policies func(ctx context.Context, serviceName string) (ListPoliciesResponse, error), logger zerolog.Logger, promRegisterer prometheus.Registerer) *PolicyDriftDetector {
+	d := &PolicyDriftDetector{
+		ClientCreator:  cc,
+		interval:       interval,
+		policyFilePath: policyFilePath,
+		issues:         issues,
+		issueLabel:     issueLabel,
+		repoFilters:    repoFilters,
+		serviceName:    serviceName,
+		policies:       policies,
+		logger:         logger,
+		metricDrift: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "policy_drift_pending_changes",
+				Help: "Gauge of the number of policy changes needed for release-manager to match the policy file of a repository",
+			},
+			[]string{"repository", "service"},
+		),
+		drifts: make(map[string]PolicyDrift),
+	}
+
+	promRegisterer.MustRegister(d.metricDrift)
+
+	return d
+}
+
+// Run detects drift every interval until ctx is cancelled. A cancelled
+// detection finishes the repository it is checking.
+func (d *PolicyDriftDetector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		err := d.detectAll(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.logger.Error().Msgf("Policy drift detection failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ServeHTTP lists the latest drift of every repository with a policy file.
+func (d *PolicyDriftDetector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	drifts := make([]PolicyDrift, 0, len(d.drifts))
+	for _, drift := range d.drifts {
+		drifts = append(drifts, drift)
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].Repository < drifts[j].Repository
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(drifts)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("Failed to encode policy drifts: %v", err)
+	}
+}
+
+func (d *PolicyDriftDetector) detectAll(ctx context.Context) error {
+	installationIDs, err := listInstallationIDs(ctx, d)
+	if err != nil {
+		return err
+	}
+	for _, installationID := range installationIDs {
+		client, err := d.NewInstallationClient(installationID)
+		if err != nil {
+			return errors.Wrapf(err, "creating new github.Client from installation id '%d'", installationID)
+		}
+		repositories, err := listRepositories(ctx, client)
+		if err != nil {
+			d.logger.Error().Int64("github_installation_id", installationID).Msgf("Policy drift detection of installation failed: %v", err)
+			continue
+		}
+		for _, repository := range repositories {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if repository.GetArchived() || any(d.repoFilters, func(filterRepo string) bool {
+				return filterRepo == repository.GetName()
+			}) {
+				continue
+			}
+
+			logger := d.logger.With().
+				Int64("github_installation_id", installationID).
+				Str("github_repository_owner", repository.GetOwner().GetLogin()).
+				Str("github_repository_name", repository.GetName()).
+				Logger()
+
+			err := d.detect(logger.WithContext(context.WithoutCancel(ctx)), client, repository)
+			if err != nil {
+				logger.Error().Msgf("Policy drift detection of repository failed: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *PolicyDriftDetector) detect(ctx context.Context, client *github.Client, repository *github.Repository) error {
+	owner := repository.GetOwner().GetLogin()
+	repo := repository.GetName()
+
+	content, found, err := readFile(ctx, client, owner, repo, d.policyFilePath, repository.GetDefaultBranch())
+	if err != nil {
+		return err
+	}
+	if !found {
+		d.forget(repository.GetFullName())
+		return nil
+	}
+
+	drift := PolicyDrift{
+		Repository: repository.GetFullName(),
+		CheckedAt:  time.Now(),
+	}
+	defer func() {
+		d.record(drift)
+	}()
+
+	policyFile, err := parsePolicyFile(content)
+	if err != nil {
+		drift.Error = err.Error()
+		return err
+	}
+	drift.Service = d.serviceName(repo)
+	_, err = policyFileService(policyFile, drift.Service)
+	if err != nil {
+		drift.Error = err.Error()
+		return err
+	}
+
+	current, err := d.policies(ctx, drift.Service)
+	if err != nil {
+		drift.Error = err.Error()
+		return err
+	}
+	drift.Plan = planPolicies(policyFile, current)
+
+	if d.issues {
+		err = d.updateIssue(ctx, client, owner, repo, drift)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *PolicyDriftDetector) record(drift PolicyDrift) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if previous, ok := d.drifts[drift.Repository]; ok && previous.Service != drift.Service {
+		d.metricDrift.DeleteLabelValues(previous.Repository, previous.Service)
+	}
+	d.drifts[drift.Repository] = drift
+	// The drift of a failing check is unknown so no stale value is reported
+	if drift.Error != "" {
+		d.metricDrift.DeleteLabelValues(drift.Repository, drift.Service)
+		return
+	}
+	d.metricDrift.WithLabelValues(drift.Repository, drift.Service).Set(float64(drift.Plan.Len()))
+}
+
+func (d *PolicyDriftDetector) forget(repository string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if previous, ok := d.drifts[repository]; ok {
+		d.metricDrift.DeleteLabelValues(previous.Repository, previous.Service)
+		delete(d.drifts, repository)
+	}
+}
+
+// updateIssue opens or updates an issue describing the drift, or closes it
+// when the drift is resolved.
+func (d *PolicyDriftDetector) updateIssue(ctx context.Context, client *github.Client, owner, repo string, drift PolicyDrift) error {
+	logger := zerolog.Ctx(ctx)
+
+	issues, _, err := client.Issues.ListByRepo(ctx, owner, repo, &github.IssueListByRepoOptions{
+		State:  "open",
+		Labels: []string{d.issueLabel},
+	})
+	if err != nil {
+		return errors.Wrap(err, "listing policy drift issues")
+	}
+	var issue *github.Issue
+	for _, i := range issues {
+		if !i.IsPullRequest() {
+			issue = i
+			break
+		}
+	}
+
+	if drift.Plan.Empty() {
+		if issue == nil {
+			return nil
+		}
+		_, _, err := client.Issues.Edit(ctx, owner, repo, issue.GetNumber(), &github.IssueRequest{
+			State: github.Ptr("closed"),
+		})
+		if err != nil {
+			return errors.Wrapf(err, "closing policy drift issue %d", issue.GetNumber())
+		}
+		logger.Info().Msgf("Policy drift issue %d closed in %s", issue.GetNumber(), repo)
+		return nil
+	}
+
+	body := fmt.Sprintf("The release-manager policies of `%s` differ from `%s`. Applying the file would:\n\n%s\n\nThe policies were probably changed with hamctl. Update `%s` or re-apply it to resolve the drift.\n%s", drift.Service, d.policyFilePath, formatPolicyPlan(drift.Plan), d.policyFilePath, policyDriftMarker)
+	if issue == nil {
+		_, _, err := client.Issues.Create(ctx, owner, repo, &github.IssueRequest{
+			Title:  github.Ptr(fmt.Sprintf("Release-manager policies of %s drifted from %s", drift.Service, d.policyFilePath)),
+			Body:   github.Ptr(body),
+			Labels: &[]string{d.issueLabel},
+		})
+		if err != nil {
+			return errors.Wrap(err, "creating policy drift issue")
+		}
+		logger.Info().Msgf("Policy drift issue created in %s", repo)
+		return nil
+	}
+	if issue.GetBody() == body {
+		return nil
+	}
+	_, _, err = client.Issues.Edit(ctx, owner, repo, issue.GetNumber(), &github.IssueRequest{
+		Body: github.Ptr(body),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "updating policy drift issue %d", issue.GetNumber())
+	}
+	logger.Info().Msgf("Policy drift issue %d updated in %s", issue.GetNumber(), repo)
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyDriftDetector(t *testing.T) {
+	detector := NewPolicyDriftDetector(nil, 0, ".release-manager/policies.yaml", false, "", nil, nil, nil, zerolog.Nop(), prometheus.NewRegistry())
+
+	detector.record(PolicyDrift{
+		Repository: "lunarway/service-b",
+		Service:    "service-b",
+	})
+	detector.record(PolicyDrift{
+		Repository: "lunarway/service-a",
+		Service:    "service-a",
+		Plan: PolicyPlan{
+			CreateAutoReleases: []AutoReleasePolicy{{Branch: "master", Environment: "prod"}},
+			DeleteAutoReleases: []AutoReleasePolicy{{ID: "auto-release-master-dev", Branch: "master", Environment: "dev"}},
+		},
+	})
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(detector.metricDrift.WithLabelValues("lunarway/service-a", "service-a")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(detector.metricDrift.WithLabelValues("lunarway/service-b", "service-b")))
+
+	recorder := httptest.NewRecorder()
+	detector.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/policy-drift", nil))
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var drifts []PolicyDrift
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &drifts))
+	require.Len(t, drifts, 2)
+	assert.Equal(t, "lunarway/service-a", drifts[0].Repository)
+	assert.Equal(t, 2, drifts[0].Plan.Len())
+	assert.True(t, drifts[1].Plan.Empty())
+
+	detector.forget("lunarway/service-a")
+	assert.Equal(t, 1, testutil.CollectAndCount(detector.metricDrift))
+
+	// Failing checks remove the drift of the repository
+	detector.record(PolicyDrift{
+		Repository: "lunarway/service-b",
+		Service:    "service-b",
+		Error:      "release-manager unavailable",
+	})
+	assert.Equal(t, 0, testutil.CollectAndCount(detector.metricDrift))
+}
+
+func TestPolicyDriftDetector_detect_otherService(t *testing.T) {
+	content, err := json.Marshal(github.RepositoryContent{
+		Type:     github.Ptr("file"),
+		Encoding: github.Ptr("base64"),
+		Content:  github.Ptr(base64.StdEncoding.EncodeToString([]byte("service: payments\n"))),
+	})
+	require.NoError(t, err)
+	client, _ := newFakeGithubClient(t, fstest.MapFS{
+		"repos/lunarway/example/contents/.release-manager/policies.yaml.json": {Data: content},
+	})
+	policies := func(ctx context.Context, serviceName string) (ListPoliciesResponse, error) {
+		t.Errorf("policies of service '%s' requested", serviceName)
+		return ListPoliciesResponse{}, nil
+	}
+	detector := NewPolicyDriftDetector(nil, 0, ".release-manager/policies.yaml", false, "", nil, func(repoName string) string { return repoName }, policies, zerolog.Nop(), prometheus.NewRegistry())
+
+	err = detector.detect(context.Background(), client, &github.Repository{
+		Name:          github.Ptr("example"),
+		FullName:      github.Ptr("lunarway/example"),
+		Owner:         &github.User{Login: github.Ptr("lunarway")},
+		DefaultBranch: github.Ptr("master"),
+	})
+
+	assert.EqualError(t, err, "service 'payments' is not the service 'example' of the repository")
+	require.Len(t, detector.drifts, 1)
+	assert.Equal(t, "example", detector.drifts["lunarway/example"].Service)
+	assert.NotEmpty(t, detector.drifts["lunarway/example"].Error)
+}
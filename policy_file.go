@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// policyPlanMarker is appended to policy plan comments so they can be updated
+// when the pull request changes.
+const policyPlanMarker = "<!-- release-manager-bot:policy-plan -->"
+
+// PolicyFile declares the release-manager policies of a service in its
+// repository. Ex.
+//
+//	autoReleases:
+//	  - branch: master
+//	    environment: dev
+//	branchRestrictions:
+//	  - environment: prod
+//	    branchRegex: ^master$
+type PolicyFile struct {
+	// Service is the service the policies belong to. It must match the
+	// service name derived from the repository name if set, so a repository
+	// cannot change the policies of other services.
+	Service            string                    `yaml:"service"`
+	AutoReleases       []AutoReleasePolicy       `yaml:"autoReleases"`
+	BranchRestrictions []BranchRestrictionPolicy `yaml:"branchRestrictions"`
+}
+
+// PolicyPlan is the changes needed for release-manager to match a policy file.
+type PolicyPlan struct {
+	CreateAutoReleases       []AutoReleasePolicy       `json:"createAutoReleases,omitempty"`
+	CreateBranchRestrictions []BranchRestrictionPolicy `json:"createBranchRestrictions,omitempty"`
+	DeleteAutoReleases       []AutoReleasePolicy       `json:"deleteAutoReleases,omitempty"`
+	DeleteBranchRestrictions []BranchRestrictionPolicy `json:"deleteBranchRestrictions,omitempty"`
+}
+
+func (plan PolicyPlan) Len() int {
+	return len(plan.CreateAutoReleases) +
+		len(plan.CreateBranchRestrictions) +
+		len(plan.DeleteAutoReleases) +
+		len(plan.DeleteBranchRestrictions)
+}
+
+func (plan PolicyPlan) Empty() bool {
+	return plan.Len() == 0
+}
+
+func parsePolicyFile(content []byte) (PolicyFile, error) {
+	var policyFile PolicyFile
+	err := yaml.Unmarshal(content, &policyFile)
+	if err != nil {
+		return PolicyFile{}, errors.Wrap(err, "parsing policy file")
+	}
+	for _, policy := range policyFile.AutoReleases {
+		if policy.Branch == "" || policy.Environment == "" {
+			return PolicyFile{}, errors.New("auto-release policies must have a branch and an environment")
+		}
+	}
+	for _, policy := range policyFile.BranchRestrictions {
+		if policy.BranchRegex == "" || policy.Environment == "" {
+			return PolicyFile{}, errors.New("branch restriction policies must have a branchRegex and an environment")
+		}
+	}
+	return policyFile, nil
+}
+
+// policyFileService returns the service of a policy file in a repository
+// whose service name is repoService. Policy files declaring another service are
+// rejected.
+func policyFileService(policyFile PolicyFile, repoService string) (string, error) {
+	if policyFile.Service != "" && policyFile.Service != repoService {
+		return "", errors.Errorf("service '%s' is not the service '%s' of the repository", policyFile.Service, repoService)
+	}
+	return repoService, nil
+}
+
+// planPolicies returns the policies to create and delete for current to match
+// declared. Policies are compared on everything but their ID.
+func planPolicies(declared PolicyFile, current ListPoliciesResponse) PolicyPlan {
+	var plan PolicyPlan
+
+	for _, policy := range declared.AutoReleases {
+		if !containsAutoRelease(current.AutoReleases, policy) {
+			plan.CreateAutoReleases = append(plan.CreateAutoReleases, policy)
+		}
+	}
+	for _, policy := range current.AutoReleases {
+		if !containsAutoRelease(declared.AutoReleases, policy) {
+			plan.DeleteAutoReleases = append(plan.DeleteAutoReleases, policy)
+		}
+	}
+
+	for _, policy := range declared.BranchRestrictions {
+		if !containsBranchRestriction(current.BranchRestrictions, policy) {
+			plan.CreateBranchRestrictions = append(plan.CreateBranchRestrictions, policy)
+		}
+	}
+	for _, policy := range current.BranchRestrictions {
+		if !containsBranchRestriction(declared.BranchRestrictions, policy) {
+			plan.DeleteBranchRestrictions = append(plan.DeleteBranchRestrictions, policy)
+		}
+	}
+
+	return plan
+}
+
+func containsAutoRelease(policies []AutoReleasePolicy, policy AutoReleasePolicy) bool {
+	for _, p := range policies {
+		if p.Branch == policy.Branch && p.Environment == policy.Environment {
+			return true
+		}
+	}
+	return false
+}
+
+func containsBranchRestriction(policies []BranchRestrictionPolicy, policy BranchRestrictionPolicy) bool {
+	for _, p := range policies {
+		if p.BranchRegex == policy.BranchRegex && p.Environment == policy.Environment {
+			return true
+		}
+	}
+	return false
+}
+
+// formatPolicyPlan formats the plan as a markdown list.
+func formatPolicyPlan(plan PolicyPlan) string {
+	if plan.Empty() {
+		return "No changes."
+	}
+	var lines []string
+	for _, policy := range plan.CreateAutoReleases {
+		lines = append(lines, fmt.Sprintf("- **create** auto-release of `%s` to `%s`", policy.Branch, policy.Environment))
+	}
+	for _, policy := range plan.CreateBranchRestrictions {
+		lines = append(lines, fmt.Sprintf("- **create** branch restriction of `%s` to `%s`", policy.Environment, policy.BranchRegex))
+	}
+	for _, policy := range plan.DeleteAutoReleases {
+		lines = append(lines, fmt.Sprintf("- **delete** auto-release of `%s` to `%s`", policy.Branch, policy.Environment))
+	}
+	for _, policy := range plan.DeleteBranchRestrictions {
+		lines = append(lines, fmt.Sprintf("- **delete** branch restriction of `%s` to `%s`", policy.Environment, policy.BranchRegex))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// handlePolicyFile posts a plan of the policy changes on pull requests into the
+// default branch changing the policy file and applies the changes to
+// release-manager when the pull request is merged. Removing the policy file
+// leaves the policies in release-manager unchanged, which is commented.
+func (handler *PRCreateHandler) handlePolicyFile(ctx context.Context, deliveryID string, payload []byte) error {
+	var event github.PullRequestEvent
+
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return errors.Wrap(err, "parsing payload")
+	}
+
+	repository := event.GetRepo()
+	pr := event.GetPullRequest()
+	prNum := event.GetNumber()
+	installationID := githubapp.GetInstallationIDFromEvent(&event)
+
+	logger := zerolog.Ctx(ctx).With().
+		Int64("github_installation_id", installationID).
+		Str("github_repository_owner", repository.GetOwner().GetLogin()).
+		Str("github_repository_name", repository.GetName()).
+		Int("github_pr_num", prNum).
+		Str("github_pr_link", pr.GetHTMLURL()).
+		Str("policy_file", handler.policyFilePath).
+		Logger()
+	ctx = logger.WithContext(ctx)
+
+	logger.Info().Msgf("Handling policy file for deliveryID: '%s'", deliveryID)
+
+	// Filters
+	// - Policies are only applied from the default branch
+	if checkPolicyFilter(ctx, "PolicyNotDefaultBranch", func() bool {
+		if pr.GetBase().GetRef() == repository.GetDefaultBranch() {
+			return false
+		}
+		logger.Info().Msgf("Policy filter NotDefaultBranch triggered. Branch: '%s'", pr.GetBase().GetRef())
+		return true
+	}) {
+		return nil
+	}
+	// - Action type
+	merged := event.GetAction() == "closed" && pr.GetMerged()
+	if checkPolicyFilter(ctx, "PolicyActionType", func() bool {
+		switch event.GetAction() {
+		case "opened", "reopened", "synchronize", "closed":
+			return false
+		}
+		logger.Info().Msgf("Policy filter ActionType triggered. Action: '%s'", event.GetAction())
+		return true
+	}) {
+		return nil
+	}
+	// - Closed without merging
+	if event.GetAction() == "closed" {
+		if checkPolicyFilter(ctx, "PolicyNotMerged", func() bool {
+			if merged {
+				return false
+			}
+			logger.Info().Msg("Policy filter NotMerged triggered")
+			return true
+		}) {
+			return nil
+		}
+	}
+	// - Ignored repositories
+	if checkPolicyFilter(ctx, "PolicyIgnoredRepo", func() bool {
+		if !any(handler.repoFilters, func(filterRepo string) bool {
+			return filterRepo == repository.GetName()
+		}) {
+			return false
+		}
+		logger.Info().Msgf("Policy filter IgnoredRepo triggered. Repo: '%s'", repository.GetName())
+		return true
+	}) {
+		return nil
+	}
+
+	client, err := handler.NewInstallationClient(installationID)
+	if err != nil {
+		return errors.Wrapf(err, "creating new github.Client from installation id '%d'", installationID)
+	}
+
+	owner := repository.GetOwner().GetLogin()
+	repo := repository.GetName()
+
+	// - Policy file not changed
+	changed, err := changesFile(ctx, client, owner, repo, prNum, handler.policyFilePath)
+	if err != nil {
+		return err
+	}
+	if checkPolicyFilter(ctx, "PolicyFileNotChanged", func() bool {
+		if changed {
+			return false
+		}
+		logger.Info().Msg("Policy filter FileNotChanged triggered")
+		return true
+	}) {
+		return nil
+	}
+
+	ref := pr.GetHead().GetSHA()
+	if merged {
+		ref = pr.GetMergeCommitSHA()
+	}
+	content, found, err := readFile(ctx, client, owner, repo, handler.policyFilePath, ref)
+	if err != nil {
+		return err
+	}
+	var message string
+	// Removing the policy file leaves the policies in release-manager as they
+	// are. The service may still be released by them so they are only
+	// deleted explicitly with hamctl.
+	if !found {
+		serviceName := getServiceName(repo, handler.config(ctx).RepoToServiceMap)
+		logger.Info().Msgf("Policy file removed; policies of service '%s' are left unchanged", serviceName)
+		if merged {
+			message = fmt.Sprintf(":information_source: `%s` was removed. The release-manager policies of `%s` are left unchanged; delete them with hamctl if they are no longer needed.", handler.policyFilePath, serviceName)
+		} else {
+			message = fmt.Sprintf("Merging this pull request removes `%s`. The release-manager policies of `%s` are left unchanged; delete them with hamctl if they are no longer needed.", handler.policyFilePath, serviceName)
+		}
+		return handler.commentPolicyResult(ctx, client, owner, repo, prNum, message, merged)
+	}
+
+	policyFile, err := parsePolicyFile(content)
+	if err != nil {
+		message = fmt.Sprintf(":x: `%s` is invalid: %v", handler.policyFilePath, err)
+		return handler.commentPolicyResult(ctx, client, owner, repo, prNum, message, merged)
+	}
+	serviceName, err := policyFileService(policyFile, getServiceName(repo, handler.config(ctx).RepoToServiceMap))
+	if err != nil {
+		message = fmt.Sprintf(":x: `%s` is invalid: %v", handler.policyFilePath, err)
+		return handler.commentPolicyResult(ctx, client, owner, repo, prNum, message, merged)
+	}
+
+	current, err := handler.retrievePolicies(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	plan := planPolicies(policyFile, current)
+
+	if !merged {
+		message = fmt.Sprintf("Merging this pull request into `%s` changes the release-manager policies of `%s`:\n\n%s", repository.GetDefaultBranch(), serviceName, formatPolicyPlan(plan))
+		return handler.commentPolicyResult(ctx, client, owner, repo, prNum, message, merged)
+	}
+
+	err = handler.applyPolicyPlan(ctx, serviceName, plan, pr.GetMergedBy().GetLogin())
+	if err != nil {
+		logger.Error().Msgf("Failed to apply policies of service '%s': %v", serviceName, err)
+		message = fmt.Sprintf(":x: Failed to apply release-manager policies of `%s`: %v\n\nPlan:\n\n%s", serviceName, err, formatPolicyPlan(plan))
+	} else {
+		message = fmt.Sprintf(":white_check_mark: Applied release-manager policies of `%s`:\n\n%s", serviceName, formatPolicyPlan(plan))
+	}
+	return handler.commentPolicyResult(ctx, client, owner, repo, prNum, message, merged)
+}
+
+// commentPolicyResult updates the plan comment of an open pull request or
+// creates a new comment with the apply result on a merged one.
+func (handler *PRCreateHandler) commentPolicyResult(ctx context.Context, client *github.Client, owner, repo string, prNum int, message string, merged bool) error {
+	logger := zerolog.Ctx(ctx)
+	body := message + "\n" + policyPlanMarker
+
+	if !merged {
+		comment, err := findBotComment(ctx, client, owner, repo, prNum, policyPlanMarker)
+		if err != nil {
+			return err
+		}
+		if comment != nil {
+			if comment.GetBody() == body {
+				return nil
+			}
+			_, _, err := client.Issues.EditComment(ctx, owner, repo, comment.GetID(), &github.IssueComment{Body: &body})
+			if err != nil {
+				return errors.Wrapf(err, "updating policy plan comment %d", comment.GetID())
+			}
+			logger.Info().Msgf("Policy plan comment updated on %s PR %d", repo, prNum)
+			return nil
+		}
+	}
+
+	_, _, err := client.Issues.CreateComment(ctx, owner, repo, prNum, &github.IssueComment{Body: &body})
+	if err != nil {
+		return errors.Wrap(err, "commenting policy plan on pull request")
+	}
+	logger.Info().Msgf("Policy comment created on %s PR %d", repo, prNum)
+	return nil
+}
+
+// applyPolicyPlan creates policies before deleting the ones they replace so a
+// service is never left without its policies. release-manager keys some
+// policies, e.g. branch restrictions, by environment so creating one replaces
+// the policy with the same ID. Policies replaced this way are not deleted.
+func (handler *PRCreateHandler) applyPolicyPlan(ctx context.Context, serviceName string, plan PolicyPlan, committer string) error {
+	applied := make(map[string]bool)
+	for _, policy := range plan.CreateAutoReleases {
+		var response ApplyPolicyResponse
+		err := sendToReleaseManager(ctx, http.MethodPatch, handler.releaseManagerURL+"/policies/auto-release", handler.config(ctx).ReleaseManagerAuthToken, ApplyAutoReleasePolicyRequest{
+			Service:       serviceName,
+			Branch:        policy.Branch,
+			Environment:   policy.Environment,
+			CommitterName: committer,
+		}, &response, handler.releaseManagerMetricsMiddleware)
+		if err != nil {
+			return errors.Wrapf(err, "applying auto-release policy of '%s' to '%s'", policy.Branch, policy.Environment)
+		}
+		applied[response.ID] = true
+	}
+	for _, policy := range plan.CreateBranchRestrictions {
+		var response ApplyPolicyResponse
+		err := sendToReleaseManager(ctx, http.MethodPatch, handler.releaseManagerURL+"/policies/branch-restriction", handler.config(ctx).ReleaseManagerAuthToken, ApplyBranchRestrictionPolicyRequest{
+			Service:       serviceName,
+			Environment:   policy.Environment,
+			BranchRegex:   policy.BranchRegex,
+			CommitterName: committer,
+		}, &response, handler.releaseManagerMetricsMiddleware)
+		if err != nil {
+			return errors.Wrapf(err, "applying branch restriction policy of '%s' to '%s'", policy.Environment, policy.BranchRegex)
+		}
+		applied[response.ID] = true
+	}
+
+	var policyIDs []string
+	for _, policy := range plan.DeleteAutoReleases {
+		if !applied[policy.ID] {
+			policyIDs = append(policyIDs, policy.ID)
+		}
+	}
+	for _, policy := range plan.DeleteBranchRestrictions {
+		if !applied[policy.ID] {
+			policyIDs = append(policyIDs, policy.ID)
+		}
+	}
+	if len(policyIDs) == 0 {
+		return nil
+	}
+	err := sendToReleaseManager(ctx, http.MethodDelete, handler.releaseManagerURL+"/policies", handler.config(ctx).ReleaseManagerAuthToken, DeletePolicyRequest{
+		Service:       serviceName,
+		PolicyIDs:     policyIDs,
+		CommitterName: committer,
+	}, &DeletePolicyResponse{}, handler.releaseManagerMetricsMiddleware)
+	if err != nil {
+		return errors.Wrapf(err, "deleting policies %v", policyIDs)
+	}
+	return nil
+}
+
+// changesFile reports whether a pull request changes the file at path.
+func changesFile(ctx context.Context, client *github.Client, owner, repo string, prNum int, path string) (bool, error) {
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := client.PullRequests.ListFiles(ctx, owner, repo, prNum, opts)
+		if err != nil {
+			return false, errors.Wrapf(err, "listing files of PR %d", prNum)
+		}
+		for _, file := range files {
+			if file.GetFilename() == path || file.GetPreviousFilename() == path {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// readFile returns the content of the file at path in ref and whether it
+// exists.
+func readFile(ctx context.Context, client *github.Client, owner, repo, path, ref string) ([]byte, bool, error) {
+	file, _, resp, err := client.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, false, nil
+		}
+		return nil, false, errors.Wrapf(err, "getting '%s' at '%s'", path, ref)
+	}
+	if file == nil {
+		// path is a directory
+		return nil, false, nil
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "decoding '%s' at '%s'", path, ref)
+	}
+	return []byte(content), true, nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicyFile(t *testing.T) {
+	content := `
+service: payments
+autoReleases:
+  - branch: master
+    environment: dev
+branchRestrictions:
+  - environment: prod
+    branchRegex: ^master$
+`
+
+	// Act
+	policyFile, err := parsePolicyFile([]byte(content))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, PolicyFile{
+		Service:            "payments",
+		AutoReleases:       []AutoReleasePolicy{{Branch: "master", Environment: "dev"}},
+		BranchRestrictions: []BranchRestrictionPolicy{{Environment: "prod", BranchRegex: "^master$"}},
+	}, policyFile)
+
+	_, err = parsePolicyFile([]byte("autoReleases:\n  - branch: master\n"))
+	assert.Error(t, err, "auto-release without environment")
+}
+
+func TestPlanPolicies(t *testing.T) {
+	declared := PolicyFile{
+		AutoReleases: []AutoReleasePolicy{
+			{Branch: "master", Environment: "dev"},
+			{Branch: "master", Environment: "staging"},
+		},
+		BranchRestrictions: []BranchRestrictionPolicy{
+			{Environment: "prod", BranchRegex: "^master$"},
+		},
+	}
+	current := ListPoliciesResponse{
+		Service: "payments",
+		AutoReleases: []AutoReleasePolicy{
+			{ID: "auto-release-master-dev", Branch: "master", Environment: "dev"},
+			{ID: "auto-release-develop-prod", Branch: "develop", Environment: "prod"},
+		},
+		BranchRestrictions: []BranchRestrictionPolicy{
+			{ID: "branch-restriction-prod", Environment: "prod", BranchRegex: "^master$"},
+		},
+	}
+
+	// Act
+	plan := planPolicies(declared, current)
+
+	// Assert
+	assert.Equal(t, PolicyPlan{
+		CreateAutoReleases: []AutoReleasePolicy{{Branch: "master", Environment: "staging"}},
+		DeleteAutoReleases: []AutoReleasePolicy{{ID: "auto-release-develop-prod", Branch: "develop", Environment: "prod"}},
+	}, plan)
+	assert.Equal(t, "- **create** auto-release of `master` to `staging`\n- **delete** auto-release of `develop` to `prod`", formatPolicyPlan(plan))
+	assert.Equal(t, "No changes.", formatPolicyPlan(planPolicies(declared, ListPoliciesResponse{
+		AutoReleases:       declared.AutoReleases,
+		BranchRestrictions: declared.BranchRestrictions,
+	})))
+}
+
+func TestPolicyFileService(t *testing.T) {
+	service, err := policyFileService(PolicyFile{}, "example")
+	require.NoError(t, err)
+	assert.Equal(t, "example", service, "no service")
+
+	service, err = policyFileService(PolicyFile{Service: "example"}, "example")
+	require.NoError(t, err)
+	assert.Equal(t, "example", service, "same service")
+
+	_, err = policyFileService(PolicyFile{Service: "payments"}, "example")
+	assert.EqualError(t, err, "service 'payments' is not the service 'example' of the repository", "other service")
+}
+
+func TestPRCreateHandler_applyPolicyPlan(t *testing.T) {
+	handler, _, fakeReleaseManager := newFakeHandler(t, fstest.MapFS{}, fstest.MapFS{})
+	plan := PolicyPlan{
+		CreateAutoReleases:       []AutoReleasePolicy{{Branch: "master", Environment: "staging"}},
+		CreateBranchRestrictions: []BranchRestrictionPolicy{{Environment: "prod", BranchRegex: "^release/"}},
+		DeleteAutoReleases:       []AutoReleasePolicy{{ID: "auto-release-develop-prod", Branch: "develop", Environment: "prod"}},
+		DeleteBranchRestrictions: []BranchRestrictionPolicy{{ID: "branch-restriction-prod", Environment: "prod", BranchRegex: "^master$"}},
+	}
+
+	err := handler.applyPolicyPlan(zerolog.Nop().WithContext(context.Background()), "example", plan, "developer")
+
+	require.NoError(t, err)
+	writes := fakeReleaseManager.Writes()
+	assert.Equal(t, []string{
+		"PATCH /policies/auto-release",
+		"PATCH /policies/branch-restriction",
+		"DELETE /policies",
+	}, writeURLs(writes))
+	require.Len(t, writes, 3)
+	assert.JSONEq(t, `{"service":"example","branch":"master","environment":"staging","committerName":"developer"}`, string(writes[0].Body))
+	assert.JSONEq(t, `{"service":"example","environment":"prod","branchRegex":"^release/","committerName":"developer"}`, string(writes[1].Body))
+	assert.JSONEq(t, `{"service":"example","policyIds":["auto-release-develop-prod"],"committerName":"developer"}`, string(writes[2].Body), "replaced branch restriction is not deleted")
+
+	t.Run("only replaced policies", func(t *testing.T) {
+		handler, _, fakeReleaseManager := newFakeHandler(t, fstest.MapFS{}, fstest.MapFS{})
+		plan := PolicyPlan{
+			CreateBranchRestrictions: []BranchRestrictionPolicy{{Environment: "prod", BranchRegex: "^release/"}},
+			DeleteBranchRestrictions: []BranchRestrictionPolicy{{ID: "branch-restriction-prod", Environment: "prod", BranchRegex: "^master$"}},
+		}
+
+		err := handler.applyPolicyPlan(zerolog.Nop().WithContext(context.Background()), "example", plan, "developer")
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"PATCH /policies/branch-restriction"}, writeURLs(fakeReleaseManager.Writes()))
+	})
+}
+
+func TestPRCreateHandler_commentPolicyResult(t *testing.T) {
+	handler, fakeGithub, _ := newFakeHandler(t, fstest.MapFS{}, fstest.MapFS{})
+	client, err := handler.NewInstallationClient(1)
+	require.NoError(t, err)
+	ctx := zerolog.Nop().WithContext(context.Background())
+
+	require.NoError(t, handler.commentPolicyResult(ctx, client, "lunarway", "example", 1, "first plan", false))
+	require.NoError(t, handler.commentPolicyResult(ctx, client, "lunarway", "example", 1, "second plan", false))
+	require.NoError(t, handler.commentPolicyResult(ctx, client, "lunarway", "example", 1, "second plan", false))
+	comments := fakeGithub.Comments("lunarway", "example", 1)
+	require.Len(t, comments, 1, "plan comment is updated")
+	assert.Equal(t, "second plan\n"+policyPlanMarker, comments[0].GetBody())
+	assert.Equal(t, []string{
+		"POST /repos/lunarway/example/issues/1/comments",
+		"PATCH /repos/lunarway/example/issues/comments/1",
+	}, writeURLs(fakeGithub.Writes()), "unchanged plan is not updated")
+
+	require.NoError(t, handler.commentPolicyResult(ctx, client, "lunarway", "example", 1, "applied", true))
+	comments = fakeGithub.Comments("lunarway", "example", 1)
+	require.Len(t, comments, 2, "apply result is a new comment")
+	assert.Equal(t, "applied\n"+policyPlanMarker, comments[1].GetBody())
+}
+
+func TestPRCreateHandler_handlePolicyFile(t *testing.T) {
+	const policyFilePath = ".release-manager/policies.yaml"
+	policyFileFixture := func(content string) *fstest.MapFile {
+		data, err := json.Marshal(github.RepositoryContent{
+			Type:     github.Ptr("file"),
+			Encoding: github.Ptr("base64"),
+			Content:  github.Ptr(base64.StdEncoding.EncodeToString([]byte(content))),
+		})
+		require.NoError(t, err)
+		return &fstest.MapFile{Data: data}
+	}
+	changedFiles := &fstest.MapFile{Data: []byte(`[{"filename":".release-manager/policies.yaml"}]`)}
+	releaseManagerFixtures := fstest.MapFS{
+		"example/policies.json": {Data: []byte(`{"service":"example","autoReleases":[{"id":"auto-release-master-dev","branch":"master","environment":"dev"}],"branchRestrictions":[{"id":"branch-restriction-prod","environment":"prod","branchRegex":"^master$"}]}`)},
+	}
+	policies := "autoReleases:\n  - branch: master\n    environment: dev\nbranchRestrictions:\n  - environment: prod\n    branchRegex: ^release/\n"
+
+	tt := []struct {
+		name     string
+		action   string
+		base     string
+		merged   bool
+		fixtures fstest.MapFS
+		comment  string
+		writes   []string
+	}{
+		{
+			name:   "plan",
+			action: "opened",
+			fixtures: fstest.MapFS{
+				"repos/lunarway/example/pulls/1/files.json":                           changedFiles,
+				"repos/lunarway/example/contents/.release-manager/policies.yaml.json": policyFileFixture(policies),
+			},
+			comment: "Merging this pull request into `master` changes the release-manager policies of `example`:\n\n- **create** branch restriction of `prod` to `^release/`\n- **delete** branch restriction of `prod` to `^master$`\n" + policyPlanMarker,
+		},
+		{
+			name:   "apply",
+			action: "closed",
+			merged: true,
+			fixtures: fstest.MapFS{
+				"repos/lunarway/example/pulls/1/files.json":                           changedFiles,
+				"repos/lunarway/example/contents/.release-manager/policies.yaml.json": policyFileFixture(policies),
+			},
+			comment: ":white_check_mark: Applied release-manager policies of `example`:\n\n- **create** branch restriction of `prod` to `^release/`\n- **delete** branch restriction of `prod` to `^master$`\n" + policyPlanMarker,
+			writes:  []string{"PATCH /policies/branch-restriction"},
+		},
+		{
+			name:   "other service",
+			action: "closed",
+			merged: true,
+			fixtures: fstest.MapFS{
+				"repos/lunarway/example/pulls/1/files.json":                           changedFiles,
+				"repos/lunarway/example/contents/.release-manager/policies.yaml.json": policyFileFixture("service: payments\n" + policies),
+			},
+			comment: ":x: `.release-manager/policies.yaml` is invalid: service 'payments' is not the service 'example' of the repository\n" + policyPlanMarker,
+		},
+		{
+			name:   "not default branch",
+			action: "opened",
+			base:   "develop",
+			fixtures: fstest.MapFS{
+				"repos/lunarway/example/pulls/1/files.json":                           changedFiles,
+				"repos/lunarway/example/contents/.release-manager/policies.yaml.json": policyFileFixture(policies),
+			},
+		},
+		{
+			name:   "merged into other branch",
+			action: "closed",
+			base:   "develop",
+			merged: true,
+			fixtures: fstest.MapFS{
+				"repos/lunarway/example/pulls/1/files.json":                           changedFiles,
+				"repos/lunarway/example/contents/.release-manager/policies.yaml.json": policyFileFixture(policies),
+			},
+		},
+		{
+			name:   "file removed",
+			action: "opened",
+			fixtures: fstest.MapFS{
+				"repos/lunarway/example/pulls/1/files.json": changedFiles,
+			},
+			comment: "Merging this pull request removes `.release-manager/policies.yaml`. The release-manager policies of `example` are left unchanged; delete them with hamctl if they are no longer needed.\n" + policyPlanMarker,
+		},
+		{
+			name:   "file removal merged",
+			action: "closed",
+			merged: true,
+			fixtures: fstest.MapFS{
+				"repos/lunarway/example/pulls/1/files.json": changedFiles,
+			},
+			comment: ":information_source: `.release-manager/policies.yaml` was removed. The release-manager policies of `example` are left unchanged; delete them with hamctl if they are no longer needed.\n" + policyPlanMarker,
+		},
+		{
+			name:     "file not changed",
+			action:   "opened",
+			fixtures: fstest.MapFS{},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, fakeGithub, fakeReleaseManager := newFakeHandler(t, tc.fixtures, releaseManagerFixtures)
+			handler.policyFilePath = policyFilePath
+			if tc.base == "" {
+				tc.base = "master"
+			}
+			payload, err := json.Marshal(github.PullRequestEvent{
+				Action: github.Ptr(tc.action),
+				Number: github.Ptr(1),
+				PullRequest: &github.PullRequest{
+					Number:         github.Ptr(1),
+					Merged:         github.Ptr(tc.merged),
+					MergeCommitSHA: github.Ptr("merge"),
+					MergedBy:       &github.User{Login: github.Ptr("developer")},
+					Base:           &github.PullRequestBranch{Ref: github.Ptr(tc.base)},
+					Head:           &github.PullRequestBranch{Ref: github.Ptr("feature"), SHA: github.Ptr("abc")},
+				},
+				Repo: &github.Repository{
+					Name:          github.Ptr("example"),
+					Owner:         &github.User{Login: github.Ptr("lunarway")},
+					DefaultBranch: github.Ptr("master"),
+				},
+				Installation: &github.Installation{ID: github.Ptr(int64(1))},
+			})
+			require.NoError(t, err)
+
+			err = handler.handlePolicyFile(zerolog.Nop().WithContext(context.Background()), "delivery", payload)
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.writes, writeURLs(fakeReleaseManager.Writes()), "release-manager writes")
+			comments := fakeGithub.Comments("lunarway", "example", 1)
+			if tc.comment == "" {
+				assert.Empty(t, comments)
+				return
+			}
+			require.Len(t, comments, 1)
+			assert.Equal(t, tc.comment, comments[0].GetBody())
+		})
+	}
+}
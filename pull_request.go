@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// commentMarker is appended to comments made by the bot so they can be found
+// and updated later.
+const commentMarker = "<!-- release-manager-bot -->"
+
+// serviceState is the release-manager state of a service used to evaluate its
+// pull requests. Status and locks are only retrieved when needed.
+type serviceState struct {
+	name      string
+	artifacts []Spec
+	policies  ListPoliciesResponse
+	status    StatusResponse
+	locks     []EnvironmentLock
+}
+
+func (handler *PRCreateHandler) retrieveArtifacts(ctx context.Context, serviceName string) ([]Spec, error) {
+	var describeArtifactResponse DescribeArtifactResponse
+	err := retrieveFromReleaseManager(ctx, fmt.Sprintf("%s/describe/artifact/%s?count=%d", handler.releaseManagerURL, serviceName, describeArtifactCount), handler.config(ctx).ReleaseManagerAuthToken, &describeArtifactResponse, handler.releaseManagerMetricsMiddleware)
+	if err != nil {
+		return nil, errors.Wrap(err, "requesting describeArtifact from release manager")
+	}
+	return describeArtifactResponse.Artifacts, nil
+}
+
+func (handler *PRCreateHandler) retrievePolicies(ctx context.Context, serviceName string) (ListPoliciesResponse, error) {
+	var policyResponse ListPoliciesResponse
+	err := retrieveFromReleaseManager(ctx, handler.releaseManagerURL+"/policies?service="+serviceName, handler.config(ctx).ReleaseManagerAuthToken, &policyResponse, handler.releaseManagerMetricsMiddleware)
+	if err != nil {
+		return ListPoliciesResponse{}, errors.Wrap(err, "requesting policy from release manager")
+	}
+	return policyResponse, nil
+}
+
+func (handler *PRCreateHandler) retrieveStatus(ctx context.Context, serviceName string) (StatusResponse, error) {
+	var statusResponse StatusResponse
+	err := retrieveFromReleaseManager(ctx, handler.releaseManagerURL+"/status?service="+serviceName, handler.config(ctx).ReleaseManagerAuthToken, &statusResponse, handler.releaseManagerMetricsMiddleware)
+	if err != nil {
+		return StatusResponse{}, errors.Wrap(err, "requesting status from release manager")
+	}
+	return statusResponse, nil
+}
+
+// updateChecks sets the enabled check runs and commit statuses on the head of
+// a pull request. With onlyIfChanged the release readiness check run is only
+// created if it differs from the latest one. It reports whether anything was
+// written.
+func (handler *PRCreateHandler) updateChecks(ctx context.Context, client *github.Client, owner, repo, headSHA string, service serviceState, autoReleaseEnvironments []string, onlyIfChanged bool) (bool, error) {
+	changed := false
+	now := time.Now()
+	outsideDeploymentWindow := handler.config(ctx).DeploymentWindows.outside(autoReleaseEnvironments, now)
+
+	if handler.releaseReadinessCheck {
+		opts := releaseReadinessCheckRun(handler.releaseReadinessCheckName, headSHA, autoReleaseEnvironments, service.artifacts, outsideDeploymentWindow)
+		created, err := handler.createCheckRun(ctx, client, owner, repo, opts, onlyIfChanged)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || created
+	}
+
+	if handler.freezeStatus {
+		status := freezeStatus(handler.freezeStatusContext, autoReleaseEnvironments, service.locks, now)
+		created, err := handler.setStatus(ctx, client, owner, repo, headSHA, status)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || created
+
+		// release-manager sends no event when a lock expires
+		expiry := nextLockExpiry(service.locks, autoReleaseEnvironments, now)
+		if !expiry.IsZero() && handler.reconcileServiceAt != nil {
+			handler.reconcileServiceAt(service.name, expiry)
+		}
+	}
+
+	if handler.deploymentWindowStatus {
+		status := deploymentWindowStatus(handler.deploymentWindowStatusContext, autoReleaseEnvironments, outsideDeploymentWindow)
+		created, err := handler.setStatus(ctx, client, owner, repo, headSHA, status)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || created
+	}
+
+	return changed, nil
+}
+
+// renderBotMessage renders the comment for a pull request auto-releasing to
+// autoReleaseEnvironments. The returned message includes the comment marker.
+func (handler *PRCreateHandler) renderBotMessage(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest, service serviceState, autoReleaseEnvironments []string) (BotMessageData, string, error) {
+	logger := zerolog.Ctx(ctx)
+	prBase := pr.GetBase().GetRef()
+	prHeadSHA := pr.GetHead().GetSHA()
+
+	// Compare with what is currently deployed
+	var releases []EnvironmentRelease
+	if len(autoReleaseEnvironments) > 0 {
+		releases = compareWithDeployed(ctx, client, owner, repo, prHeadSHA, autoReleaseEnvironments, service.status, service.artifacts, *logger)
+	}
+
+	messageData := BotMessageData{
+		Branch:                  prBase,
+		HeadSHA:                 prHeadSHA,
+		AutoReleaseEnvironments: autoReleaseEnvironments,
+		Releases:                releases,
+		OutsideDeploymentWindow: handler.config(ctx).DeploymentWindows.outside(autoReleaseEnvironments, time.Now()),
+		Template:                handler.config(ctx).MessageTemplate,
+	}
+	if artifact, ok := findArtifactBySHA(prHeadSHA, service.artifacts); ok {
+		messageData.ArtifactID = artifact.ID
+		messageData.Stages = decodeStages(artifact.Stages)
+	}
+
+	// Mention the owning squad when releasing to sensitive environments
+	if len(service.artifacts) > 0 {
+		squad := service.artifacts[0].Squad
+		sensitiveEnvironments := sensitiveAutoReleases(autoReleaseEnvironments, handler.config(ctx).SensitiveEnvironments)
+		team := squadTeam(squad, handler.config(ctx).SquadToTeamMap)
+		messageData.Squad = squad
+		messageData.SensitiveEnvironments = sensitiveEnvironments
+		if len(sensitiveEnvironments) > 0 && team != "" {
+			messageData.SquadTeam = fmt.Sprintf("@%s/%s", owner, team)
+		}
+	}
+
+	botMessage, err := BotMessage(messageData)
+	if err != nil {
+		return BotMessageData{}, "", errors.Wrapf(err, "creating bot message")
+	}
+
+	return messageData, botMessage + "\n" + commentMarker, nil
+}
+
+// findBotComment returns the latest comment on a pull request containing marker
+// or nil if there is none.
+func findBotComment(ctx context.Context, client *github.Client, owner, repo string, prNum int, marker string) (*github.IssueComment, error) {
+	var latest *github.IssueComment
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, owner, repo, prNum, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing comments of PR %d", prNum)
+		}
+		for _, comment := range comments {
+			if strings.Contains(comment.GetBody(), marker) {
+				latest = comment
+			}
+		}
+		if resp.NextPage == 0 {
+			return latest, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// reconcilePullRequests re-evaluates the open pull requests of a repository
+// with the same logic as Handle and updates their comment, checks, statuses
+// and labels where they changed. Comments are only updated, never created. It
+// returns the number of pull requests that were updated.
+func (handler *PRCreateHandler) reconcilePullRequests(ctx context.Context, client *github.Client, repository *github.Repository, pullRequests []*github.PullRequest) (int, error) {
+	ctx = withConfig(ctx, handler.config(ctx))
+	logger := zerolog.Ctx(ctx)
+	serviceName := getServiceName(repository.GetName(), handler.config(ctx).RepoToServiceMap)
+
+	artifacts, err := handler.retrieveArtifacts(ctx, serviceName)
+	if err != nil {
+		return 0, err
+	}
+	if len(artifacts) == 0 {
+		logger.Debug().Msgf("Filter UnmanagedService triggered. Service: '%s'", serviceName)
+		return 0, nil
+	}
+
+	policies, err := handler.retrievePolicies(ctx, serviceName)
+	if err != nil {
+		return 0, err
+	}
+
+	service := serviceState{
+		name:      serviceName,
+		artifacts: artifacts,
+		policies:  policies,
+	}
+	if len(policies.AutoReleases) > 0 {
+		service.status, err = handler.retrieveStatus(ctx, serviceName)
+		if err != nil {
+			return 0, err
+		}
+		if handler.freezeStatus {
+			service.locks, err = handler.retrieveLocks(ctx, serviceName)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	updated := 0
+	for _, pr := range pullRequests {
+		prLogger := logger.With().
+			Int("github_pr_num", pr.GetNumber()).
+			Str("github_pr_link", pr.GetHTMLURL()).
+			Logger()
+
+		changed, err := handler.reconcilePullRequest(prLogger.WithContext(ctx), client, repository, pr, service)
+		if changed {
+			updated++
+		}
+		if err != nil {
+			return updated, errors.Wrapf(err, "reconciling PR %d", pr.GetNumber())
+		}
+	}
+	return updated, nil
+}
+
+func (handler *PRCreateHandler) reconcilePullRequest(ctx context.Context, client *github.Client, repository *github.Repository, pr *github.PullRequest, service serviceState) (bool, error) {
+	logger := zerolog.Ctx(ctx)
+	owner := repository.GetOwner().GetLogin()
+	repo := repository.GetName()
+	autoReleaseEnvironments := matchAutoReleases(service.policies.AutoReleases, pr.GetBase().GetRef())
+
+	changed, err := handler.updateChecks(ctx, client, owner, repo, pr.GetHead().GetSHA(), service, autoReleaseEnvironments, true)
+	if err != nil {
+		return changed, err
+	}
+
+	if handler.autoReleaseLabels {
+		labelsChanged, err := handler.syncAutoReleaseLabels(ctx, client, owner, repo, pr.GetNumber(), pr.Labels, autoReleaseEnvironments)
+		changed = changed || labelsChanged
+		if err != nil {
+			return changed, errors.Wrap(err, "syncing auto-release labels")
+		}
+	}
+
+	comment, err := findBotComment(ctx, client, owner, repo, pr.GetNumber(), commentMarker)
+	if err != nil {
+		return changed, err
+	}
+	if comment == nil {
+		return changed, nil
+	}
+	_, botMessage, err := handler.renderBotMessage(ctx, client, owner, repo, pr, service, autoReleaseEnvironments)
+	if err != nil {
+		return changed, err
+	}
+	if comment.GetBody() == botMessage {
+		return changed, nil
+	}
+	_, _, err = client.Issues.EditComment(ctx, owner, repo, comment.GetID(), &github.IssueComment{
+		Body: &botMessage,
+	})
+	if err != nil {
+		return changed, errors.Wrapf(err, "updating comment %d", comment.GetID())
+	}
+	logger.Info().Msgf("Comment updated on %s PR %d", repo, pr.GetNumber())
+
+	return true, nil
+}
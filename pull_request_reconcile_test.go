@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkRunsFixture returns a check runs response with the latest check run
+// created with opts.
+func checkRunsFixture(t *testing.T, opts github.CreateCheckRunOptions) *fstest.MapFile {
+	t.Helper()
+	content, err := json.Marshal(github.ListCheckRunsResults{
+		Total: github.Ptr(1),
+		CheckRuns: []*github.CheckRun{{
+			Name:       github.Ptr(opts.Name),
+			HeadSHA:    github.Ptr(opts.HeadSHA),
+			Conclusion: opts.Conclusion,
+			Output:     &github.CheckRunOutput{Title: opts.GetOutput().Title, Text: opts.GetOutput().Text},
+		}},
+	})
+	require.NoError(t, err)
+	return &fstest.MapFile{Data: content}
+}
+
+func writeURLs(writes []DryRunWrite) []string {
+	var urls []string
+	for _, write := range writes {
+		urls = append(urls, write.Method+" "+write.URL)
+	}
+	return urls
+}
+
+func TestPRCreateHandler_createCheckRun(t *testing.T) {
+	failing := releaseReadinessCheckRun("release-manager/artifact", "abc", []string{"dev"}, nil, nil)
+	succeeding := releaseReadinessCheckRun("release-manager/artifact", "abc", []string{"dev"}, []Spec{{ID: "master-abc", Application: Repository{SHA: "abc"}}}, nil)
+
+	tt := []struct {
+		name          string
+		latest        github.CreateCheckRunOptions
+		opts          github.CreateCheckRunOptions
+		onlyIfChanged bool
+		created       bool
+	}{
+		{name: "unchanged", latest: failing, opts: failing, onlyIfChanged: false, created: true},
+		{name: "unchanged only if changed", latest: failing, opts: failing, onlyIfChanged: true, created: false},
+		{name: "changed only if changed", latest: failing, opts: succeeding, onlyIfChanged: true, created: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &PRCreateHandler{}
+			client, fakeGithub := newFakeGithubClient(t, fstest.MapFS{
+				"repos/lunarway/example/commits/abc/check-runs.json": checkRunsFixture(t, tc.latest),
+			})
+
+			created, err := handler.createCheckRun(zerolog.Nop().WithContext(context.Background()), client, "lunarway", "example", tc.opts, tc.onlyIfChanged)
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.created, created)
+			if tc.created {
+				assert.Equal(t, []string{"POST /repos/lunarway/example/check-runs"}, writeURLs(fakeGithub.Writes()))
+			} else {
+				assert.Empty(t, fakeGithub.Writes())
+			}
+		})
+	}
+
+	t.Run("no previous check run", func(t *testing.T) {
+		handler := &PRCreateHandler{}
+		client, fakeGithub := newFakeGithubClient(t, fstest.MapFS{})
+
+		created, err := handler.createCheckRun(zerolog.Nop().WithContext(context.Background()), client, "lunarway", "example", failing, true)
+
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.Len(t, fakeGithub.Writes(), 1)
+	})
+}
+
+func TestPRCreateHandler_updateChecks(t *testing.T) {
+	service := serviceState{
+		name:  "example",
+		locks: []EnvironmentLock{{Environment: "prod", LockedBy: "alice"}},
+	}
+	latest := releaseReadinessCheckRun("release-manager/artifact", "abc", []string{"dev", "prod"}, nil, nil)
+	statuses, err := json.Marshal([]github.RepoStatus{freezeStatus("release-manager/freeze", []string{"dev", "prod"}, service.locks, time.Now())})
+	require.NoError(t, err)
+
+	tt := []struct {
+		name          string
+		fixtures      fstest.MapFS
+		onlyIfChanged bool
+		changed       bool
+		writes        []string
+	}{
+		{
+			name:     "nothing reported",
+			fixtures: fstest.MapFS{},
+			changed:  true,
+			writes:   []string{"POST /repos/lunarway/example/check-runs", "POST /repos/lunarway/example/statuses/abc"},
+		},
+		{
+			name: "unchanged check run recreated",
+			fixtures: fstest.MapFS{
+				"repos/lunarway/example/commits/abc/check-runs.json": checkRunsFixture(t, latest),
+				"repos/lunarway/example/commits/abc/statuses.json":   {Data: statuses},
+			},
+			changed: true,
+			writes:  []string{"POST /repos/lunarway/example/check-runs"},
+		},
+		{
+			name: "unchanged only if changed",
+			fixtures: fstest.MapFS{
+				"repos/lunarway/example/commits/abc/check-runs.json": checkRunsFixture(t, latest),
+				"repos/lunarway/example/commits/abc/statuses.json":   {Data: statuses},
+			},
+			onlyIfChanged: true,
+			changed:       false,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &PRCreateHandler{
+				releaseReadinessCheck:     true,
+				releaseReadinessCheckName: "release-manager/artifact",
+				freezeStatus:              true,
+				freezeStatusContext:       "release-manager/freeze",
+			}
+			handler.reloadable.Store(&ReloadableConfig{})
+			client, fakeGithub := newFakeGithubClient(t, tc.fixtures)
+
+			changed, err := handler.updateChecks(zerolog.Nop().WithContext(context.Background()), client, "lunarway", "example", "abc", service, []string{"dev", "prod"}, tc.onlyIfChanged)
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.changed, changed)
+			assert.Equal(t, tc.writes, writeURLs(fakeGithub.Writes()))
+		})
+	}
+}
+
+func TestPRCreateHandler_reconcilePullRequest(t *testing.T) {
+	pr := &github.PullRequest{
+		Number: github.Ptr(1),
+		Base:   &github.PullRequestBranch{Ref: github.Ptr("master")},
+		Head:   &github.PullRequestBranch{Ref: github.Ptr("feature"), SHA: github.Ptr("abc")},
+	}
+	repository := &github.Repository{
+		Name:  github.Ptr("example"),
+		Owner: &github.User{Login: github.Ptr("lunarway")},
+	}
+	service := serviceState{
+		name:      "example",
+		artifacts: []Spec{{ID: "master-abc", Squad: "aura"}},
+		policies:  ListPoliciesResponse{AutoReleases: []AutoReleasePolicy{{Branch: "master", Environment: "dev"}}},
+	}
+	expectedComment := "'master' will auto-release to: dev\n" + commentMarker
+
+	tt := []struct {
+		name    string
+		comment string
+		changed bool
+		writes  []string
+	}{
+		{
+			name:    "outdated comment",
+			comment: "'master' will auto-release to: dev prod\n" + commentMarker,
+			changed: true,
+			writes:  []string{"PATCH /repos/lunarway/example/issues/comments/1"},
+		},
+		{
+			name:    "up to date comment",
+			comment: expectedComment,
+			changed: false,
+		},
+		{
+			name:    "no comment",
+			changed: false,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, fakeGithub, fakeReleaseManager := newFakeHandler(t, fstest.MapFS{}, fstest.MapFS{})
+			client, err := handler.NewInstallationClient(1)
+			require.NoError(t, err)
+			ctx := zerolog.Nop().WithContext(context.Background())
+			if tc.comment != "" {
+				_, _, err := client.Issues.CreateComment(ctx, "lunarway", "example", 1, &github.IssueComment{Body: github.Ptr(tc.comment)})
+				require.NoError(t, err)
+			}
+			existingWrites := len(fakeGithub.Writes())
+
+			changed, err := handler.reconcilePullRequest(ctx, client, repository, pr, service)
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.changed, changed)
+			assert.Equal(t, tc.writes, writeURLs(fakeGithub.Writes()[existingWrites:]))
+			assert.Empty(t, fakeReleaseManager.Writes())
+			comments := fakeGithub.Comments("lunarway", "example", 1)
+			if tc.comment == "" {
+				assert.Empty(t, comments, "comments are not created")
+				return
+			}
+			require.Len(t, comments, 1)
+			assert.Equal(t, expectedComment, comments[0].GetBody())
+		})
+	}
+}
+
+func TestPRCreateHandler_updateChecks_lockExpiry(t *testing.T) {
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	service := serviceState{
+		name: "example",
+		locks: []EnvironmentLock{
+			{Environment: "prod", Kind: "freeze", LockedBy: "alice", Until: until.Add(time.Hour)},
+			{Environment: "staging", Kind: "freeze", LockedBy: "alice", Until: until},
+		},
+	}
+	var scheduled []string
+	handler := &PRCreateHandler{
+		freezeStatus:        true,
+		freezeStatusContext: "release-manager/freeze",
+		reconcileServiceAt: func(service string, at time.Time) {
+			scheduled = append(scheduled, service+" "+at.Format(time.RFC3339))
+		},
+	}
+	handler.reloadable.Store(&ReloadableConfig{})
+	client, _ := newFakeGithubClient(t, fstest.MapFS{})
+
+	_, err := handler.updateChecks(zerolog.Nop().WithContext(context.Background()), client, "lunarway", "example", "abc", service, []string{"staging", "prod"}, false)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example " + until.Format(time.RFC3339)}, scheduled, "reconciled when the first freeze ends")
+}
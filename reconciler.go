@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+// ReconcileFunc reconciles the open pull requests of a repository and returns
+// the number of pull requests that were updated.
+type ReconcileFunc func(ctx context.Context, client *github.Client, repository *github.Repository, pullRequests []*github.PullRequest) (int, error)
+
+// Reconciler periodically visits the open pull requests of every repository
+// of every installation of the app. It keeps state that depends on
+// release-manager up to date between webhook events.
+type Reconciler struct {
+	githubapp.ClientCreator
+
+	interval           time.Duration
+	repositoryDelay    time.Duration
+	minRateRemaining   int
+	repoFilters        []string
+	reconcile          ReconcileFunc
+	index              *serviceIndex
+	logger             zerolog.Logger
+	metricScanned      prometheus.Counter
+	metricUpdated      prometheus.Counter
+	metricErrors       prometheus.Counter
+	metricRateLimitHit prometheus.Counter
+
+	mu        sync.Mutex
+	scheduled map[string]time.Time
+}
+
+// NewReconciler creates a Reconciler. Between repositories it waits
+// repositoryDelay and, if fewer than minRateRemaining GitHub requests remain for
+// the installation, until the rate limit resets. serviceName maps repository
+// names to service names for reconciling single services.
+func NewReconciler(cc githubapp.ClientCreator, interval, repositoryDelay time.Duration, minRateRemaining int, repoFilters []string, serviceName func(repoName string) string, reconcile ReconcileFunc, logger zerolog.Logger, promRegisterer prometheus.Registerer) *Reconciler {
+	r := &Reconciler{
+		ClientCreator:    cc,
+		interval:         interval,
+		repositoryDelay:  repositoryDelay,
+		minRateRemaining: minRateRemaining,
+		repoFilters:      repoFilters,
+		reconcile:        reconcile,
+		index: &serviceIndex{
+			ClientCreator:      cc,
+			serviceName:        serviceName,
+			maxAge:             time.Hour,
+			minRebuildInterval: time.Minute,
+		},
+		logger:    logger,
+		scheduled: make(map[string]time.Time),
+		metricScanned: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "reconcile_pull_requests_scanned_total",
+			Help: "Counter of open pull requests scanned by reconciliation",
+		}),
+		metricUpdated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "reconcile_pull_requests_updated_total",
+			Help: "Counter of open pull requests where reconciliation updated comments, checks, statuses or labels",
+		}),
+		metricErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "reconcile_errors_total",
+			Help: "Counter of errors reconciling installations and repositories",
+		}),
+		metricRateLimitHit: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "reconcile_ratelimit_waits_total",
+			Help: "Counter of reconciliations paused until the Github rate limit reset",
+		}),
+	}
+
+	promRegisterer.MustRegister(r.metricScanned)
+	promRegisterer.MustRegister(r.metricUpdated)
+	promRegisterer.MustRegister(r.metricErrors)
+	promRegisterer.MustRegister(r.metricRateLimitHit)
+
+	return r
+}
+
+// Run reconciles every interval until ctx is cancelled. A cancelled
+// reconciliation finishes the repository it is reconciling.
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			start := time.Now()
+			err := r.reconcileAll(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				r.metricErrors.Inc()
+				r.logger.Error().Msgf("Reconciliation failed: %v", err)
+				continue
+			}
+			r.logger.Info().Msgf("Reconciliation completed in %s", time.Since(start))
+		}
+	}
+}
+
+// InvalidateServices rebuilds the repositories of services on the next
+// ReconcileService. It must be called when the repository to service map is
+// reloaded.
+func (r *Reconciler) InvalidateServices() {
+	r.index.invalidate()
+}
+
+// ReconcileServiceAt reconciles service at in the background of workers, eg.
+// when a lock expires without an event from release-manager. Only the earliest
+// pending reconciliation of a service is kept; it schedules later ones again.
+func (r *Reconciler) ReconcileServiceAt(ctx context.Context, workers *workerGroup, service string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if scheduled, ok := r.scheduled[service]; ok && !scheduled.After(at) {
+		return
+	}
+	r.scheduled[service] = at
+
+	time.AfterFunc(time.Until(at), func() {
+		r.mu.Lock()
+		if !r.scheduled[service].Equal(at) {
+			r.mu.Unlock()
+			return
+		}
+		delete(r.scheduled, service)
+		r.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+		workers.Go(func() {
+			r.logger.Info().Msgf("Reconciling service '%s' scheduled at %s", service, at.Format(time.RFC3339))
+			err := r.ReconcileService(ctx, service)
+			if err != nil {
+				r.logger.Error().Msgf("Scheduled reconciliation of service '%s' failed: %v", service, err)
+			}
+		})
+	})
+}
+
+// ReconcileService reconciles the open pull requests of the repositories of a
+// service.
+func (r *Reconciler) ReconcileService(ctx context.Context, service string) error {
+	refs, err := r.index.repositories(ctx, service)
+	if err != nil {
+		return errors.Wrapf(err, "finding repositories of service '%s'", service)
+	}
+	if len(refs) == 0 {
+		r.logger.Info().Msgf("No repositories found for service '%s'", service)
+		return nil
+	}
+
+	for _, ref := range refs {
+		if r.ignored(ref.repository) {
+			continue
+		}
+
+		client, err := r.NewInstallationClient(ref.installationID)
+		if err != nil {
+			return errors.Wrapf(err, "creating new github.Client from installation id '%d'", ref.installationID)
+		}
+
+		logger := r.logger.With().
+			Int64("github_installation_id", ref.installationID).
+			Str("github_repository_owner", ref.repository.GetOwner().GetLogin()).
+			Str("github_repository_name", ref.repository.GetName()).
+			Logger()
+
+		err = r.reconcileRepository(logger.WithContext(ctx), client, ref.repository)
+		if err != nil {
+			r.metricErrors.Inc()
+			return errors.Wrapf(err, "reconciling repository '%s'", ref.repository.GetFullName())
+		}
+	}
+	return nil
+}
+
+func (r *Reconciler) reconcileAll(ctx context.Context) error {
+	installationIDs, err := listInstallationIDs(ctx, r)
+	if err != nil {
+		return err
+	}
+	for _, installationID := range installationIDs {
+		err := r.reconcileInstallation(ctx, installationID)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// Continue with other installations
+			r.metricErrors.Inc()
+			r.logger.Error().Int64("github_installation_id", installationID).Msgf("Reconciliation of installation failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func (r *Reconciler) reconcileInstallation(ctx context.Context, installationID int64) error {
+	client, err := r.NewInstallationClient(installationID)
+	if err != nil {
+		return errors.Wrapf(err, "creating new github.Client from installation id '%d'", installationID)
+	}
+
+	repositories, err := listRepositories(ctx, client)
+	if err != nil {
+		return err
+	}
+	for _, repository := range repositories {
+		if r.ignored(repository) {
+			continue
+		}
+
+		err := r.reconcileAndPace(ctx, installationID, client, repository)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileAndPace reconciles a repository and waits before the next one. Only
+// errors that should stop reconciling the installation are returned.
+func (r *Reconciler) reconcileAndPace(ctx context.Context, installationID int64, client *github.Client, repository *github.Repository) error {
+	logger := r.logger.With().
+		Int64("github_installation_id", installationID).
+		Str("github_repository_owner", repository.GetOwner().GetLogin()).
+		Str("github_repository_name", repository.GetName()).
+		Logger()
+
+	// The repository is reconciled to completion during shutdown
+	err := r.reconcileRepository(logger.WithContext(context.WithoutCancel(ctx)), client, repository)
+	if err != nil {
+		// Continue with other repositories
+		r.metricErrors.Inc()
+		logger.Error().Msgf("Reconciliation of repository failed: %v", err)
+	}
+
+	return r.pace(ctx, client)
+}
+
+func (r *Reconciler) ignored(repository *github.Repository) bool {
+	return repository.GetArchived() || any(r.repoFilters, func(filterRepo string) bool {
+		return filterRepo == repository.GetName()
+	})
+}
+
+func (r *Reconciler) reconcileRepository(ctx context.Context, client *github.Client, repository *github.Repository) error {
+	var pullRequests []*github.PullRequest
+	opts := &github.PullRequestListOptions{
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		page, resp, err := client.PullRequests.List(ctx, repository.GetOwner().GetLogin(), repository.GetName(), opts)
+		if err != nil {
+			return errors.Wrap(err, "listing open pull requests")
+		}
+		pullRequests = append(pullRequests, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if len(pullRequests) == 0 {
+		return nil
+	}
+	r.metricScanned.Add(float64(len(pullRequests)))
+	updated, err := r.reconcile(ctx, client, repository, pullRequests)
+	r.metricUpdated.Add(float64(updated))
+	return err
+}
+
+// pace waits between repositories to spread requests over time and until the
+// rate limit resets if the installation is close to exhausting it.
+func (r *Reconciler) pace(ctx context.Context, client *github.Client) error {
+	wait := r.repositoryDelay
+
+	// Requests to the rate limit endpoint do not count against the rate limit
+	limits, _, err := client.RateLimit.Get(ctx)
+	if err != nil {
+		return errors.Wrap(err, "getting rate limit")
+	}
+	if core := limits.GetCore(); core != nil && core.Remaining < r.minRateRemaining {
+		r.metricRateLimitHit.Inc()
+		untilReset := time.Until(core.Reset.Time)
+		r.logger.Info().Msgf("Github rate limit has %d requests remaining; pausing reconciliation for %s", core.Remaining, untilReset)
+		if untilReset > wait {
+			wait = untilReset
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/rs/zerolog"
+)
+
+// EnvironmentRelease describes what merging a pull request releases to an
+// auto-release environment compared with what is currently deployed there.
+type EnvironmentRelease struct {
+	Environment        string
+	DeployedArtifactID string
+	DeployedSHA        string
+	HeadSHA            string
+	CommitCount        int
+	CompareURL         string
+}
+
+// compareWithDeployed builds an EnvironmentRelease for each auto-release
+// environment. The deployed commit is looked up in artifacts and otherwise
+// taken from the artifact ID in the environment status, as artifacts only
+// holds the latest describeArtifactCount artifacts. Comparisons that cannot be
+// made, e.g. because nothing is deployed, leave the commit count and compare
+// link empty.
+func compareWithDeployed(ctx context.Context, client *github.Client, owner, repo, headSHA string, environments []string, status StatusResponse, artifacts []Spec, logger zerolog.Logger) []EnvironmentRelease {
+	var releases []EnvironmentRelease
+	for _, environment := range environments {
+		release := EnvironmentRelease{
+			Environment: environment,
+			HeadSHA:     headSHA,
+		}
+
+		deployed, ok := findEnvironment(status, environment)
+		if !ok || deployed.Tag == "" {
+			releases = append(releases, release)
+			continue
+		}
+		release.DeployedArtifactID = deployed.Tag
+		release.DeployedSHA = artifactSHA(deployed.Tag, artifacts)
+		if release.DeployedSHA == "" {
+			release.DeployedSHA = artifactIDSHA(deployed.Tag)
+		}
+		if release.DeployedSHA == "" || headSHA == "" {
+			releases = append(releases, release)
+			continue
+		}
+
+		comparison, _, err := client.Repositories.CompareCommits(ctx, owner, repo, release.DeployedSHA, headSHA, nil)
+		if err != nil {
+			logger.Warn().Msgf("Failed to compare deployed '%s' with head '%s' for environment '%s': %v", release.DeployedSHA, headSHA, environment, err)
+			releases = append(releases, release)
+			continue
+		}
+		release.CommitCount = comparison.GetAheadBy()
+		release.CompareURL = comparison.GetHTMLURL()
+
+		releases = append(releases, release)
+	}
+	return releases
+}
+
+func findEnvironment(status StatusResponse, name string) (Environment, bool) {
+	for _, environment := range status.Environments {
+		if environment.Name == name {
+			return environment, true
+		}
+	}
+	return Environment{}, false
+}
+
+// artifactSHA returns the application SHA of the artifact with the given ID or
+// an empty string if the artifact is not among the described artifacts.
+func artifactSHA(artifactID string, artifacts []Spec) string {
+	for _, artifact := range artifacts {
+		if artifact.ID == artifactID {
+			return artifact.Application.SHA
+		}
+	}
+	return ""
+}
+
+// artifactIDCommit matches the abbreviated commit SHA of artifact IDs
+// formatted as '<branch>-<commit>-<build>'.
+var artifactIDCommit = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// artifactIDSHA returns the abbreviated application SHA encoded in an
+// artifact ID or an empty string if the ID is not formatted as
+// '<branch>-<commit>-<build>'.
+func artifactIDSHA(artifactID string) string {
+	parts := strings.Split(artifactID, "-")
+	if len(parts) < 3 {
+		return ""
+	}
+	sha := parts[len(parts)-2]
+	if !artifactIDCommit.MatchString(sha) {
+		return ""
+	}
+	return sha
+}
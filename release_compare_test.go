@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeGithubClient creates a Github client of a FakeGithub with the
+// fixtures.
+func newFakeGithubClient(t *testing.T, fixtures fstest.MapFS) (*github.Client, *FakeGithub) {
+	t.Helper()
+	fake := NewFakeGithub(fixtures, "")
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+	return client, fake
+}
+
+func TestArtifactIDSHA(t *testing.T) {
+	tt := []struct {
+		artifactID string
+		sha        string
+	}{
+		{artifactID: "master-1234567890-abcdef1234", sha: "1234567890"},
+		{artifactID: "feature-x-1234567-abcdef1234", sha: "1234567"},
+		{artifactID: "master-abc", sha: ""},
+		{artifactID: "master-notasha-abcdef1234", sha: ""},
+		{artifactID: "", sha: ""},
+	}
+	for _, tc := range tt {
+		t.Run(tc.artifactID, func(t *testing.T) {
+			assert.Equal(t, tc.sha, artifactIDSHA(tc.artifactID))
+		})
+	}
+}
+
+func TestCompareWithDeployed(t *testing.T) {
+	client, _ := newFakeGithubClient(t, fstest.MapFS{
+		"repos/lunarway/example/compare/aaaaaaaaaa...head.json": {Data: []byte(`{"ahead_by":2,"html_url":"https://github.com/lunarway/example/compare/aaaaaaaaaa...head"}`)},
+		"repos/lunarway/example/compare/1234567890...head.json": {Data: []byte(`{"ahead_by":5,"html_url":"https://github.com/lunarway/example/compare/1234567890...head"}`)},
+	})
+	status := StatusResponse{Environments: []Environment{
+		{Name: "dev", Tag: "master-aaaaaaaaaa-0000000000"},
+		{Name: "staging", Tag: "master-1234567890-0000000000"},
+		{Name: "prod", Tag: "master-abc"},
+		{Name: "missing", Tag: "master-bbbbbbbbbb-0000000000"},
+	}}
+	artifacts := []Spec{
+		{ID: "master-aaaaaaaaaa-0000000000", Application: Repository{SHA: "aaaaaaaaaa"}},
+	}
+
+	releases := compareWithDeployed(context.Background(), client, "lunarway", "example", "head", []string{"dev", "staging", "prod", "missing", "none"}, status, artifacts, zerolog.Nop())
+
+	assert.Equal(t, []EnvironmentRelease{
+		{Environment: "dev", DeployedArtifactID: "master-aaaaaaaaaa-0000000000", DeployedSHA: "aaaaaaaaaa", HeadSHA: "head", CommitCount: 2, CompareURL: "https://github.com/lunarway/example/compare/aaaaaaaaaa...head"},
+		{Environment: "staging", DeployedArtifactID: "master-1234567890-0000000000", DeployedSHA: "1234567890", HeadSHA: "head", CommitCount: 5, CompareURL: "https://github.com/lunarway/example/compare/1234567890...head"},
+		{Environment: "prod", DeployedArtifactID: "master-abc", HeadSHA: "head"},
+		{Environment: "missing", DeployedArtifactID: "master-bbbbbbbbbb-0000000000", DeployedSHA: "bbbbbbbbbb", HeadSHA: "head"},
+		{Environment: "none", HeadSHA: "head"},
+	}, releases)
+}
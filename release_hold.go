@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+func hasLabel(labels []*github.Label, name string) bool {
+	for _, label := range labels {
+		if label.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseHoldID returns the ID of the hold a pull request places on an
+// environment. Holds are per pull request so lifting one does not lift holds
+// placed by other pull requests on the same branch.
+func releaseHoldID(owner, repo string, number int, environment string) string {
+	return fmt.Sprintf("pull-request-%s-%s-%d-%s", owner, repo, number, environment)
+}
+
+// updateReleaseHold places or lifts release holds in release-manager for the
+// auto-release environments of a merged pull request based on the presence of
+// the hold label. The caller must filter out unmerged pull requests and
+// labels other than the hold label.
+func (handler *PRCreateHandler) updateReleaseHold(ctx context.Context, client *github.Client, event *github.PullRequestEvent, serviceName string, autoReleaseEnvironments []string) error {
+	logger := zerolog.Ctx(ctx)
+	pr := event.GetPullRequest()
+
+	// Filters
+	// - Nothing to hold
+	if checkFilter(ctx, "NoAutoRelease", func() bool {
+		if len(autoReleaseEnvironments) > 0 {
+			return false
+		}
+		logger.Info().Msgf("Filter NoAutoRelease triggered. Branch: '%s'", pr.GetBase().GetRef())
+		return true
+	}) {
+		return nil
+	}
+
+	hold := hasLabel(pr.Labels, handler.releaseHoldLabel)
+	// - Merged without the hold label
+	if checkFilter(ctx, "NoHoldLabel", func() bool {
+		if event.GetAction() != "closed" || hold {
+			return false
+		}
+		logger.Info().Msg("Filter NoHoldLabel triggered")
+		return true
+	}) {
+		return nil
+	}
+
+	repositoryOwner := event.GetRepo().GetOwner().GetLogin()
+	repositoryName := event.GetRepo().GetName()
+	for _, environment := range autoReleaseEnvironments {
+		var err error
+		holdID := releaseHoldID(repositoryOwner, repositoryName, pr.GetNumber(), environment)
+		if hold {
+			err = sendToReleaseManager(ctx, http.MethodPost, handler.releaseManagerURL+"/holds", handler.config(ctx).ReleaseManagerAuthToken, ReleaseHoldRequest{
+				ID:          holdID,
+				Service:     serviceName,
+				Environment: environment,
+				Branch:      pr.GetBase().GetRef(),
+				Reason:      fmt.Sprintf("Label '%s' on %s", handler.releaseHoldLabel, pr.GetHTMLURL()),
+				CreatedBy:   event.GetSender().GetLogin(),
+			}, &ReleaseHoldResponse{}, handler.releaseManagerMetricsMiddleware)
+		} else {
+			err = sendToReleaseManager(ctx, http.MethodDelete, handler.releaseManagerURL+"/holds", handler.config(ctx).ReleaseManagerAuthToken, DeleteReleaseHoldRequest{
+				ID:          holdID,
+				Service:     serviceName,
+				Environment: environment,
+				Branch:      pr.GetBase().GetRef(),
+			}, nil, handler.releaseManagerMetricsMiddleware)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "updating release hold of service '%s' in environment '%s'", serviceName, environment)
+		}
+	}
+
+	var message string
+	if hold {
+		message = fmt.Sprintf("Auto-release of '%s' to %s is on hold because of the label '%s'. Remove the label to lift the hold.", pr.GetBase().GetRef(), strings.Join(autoReleaseEnvironments, ", "), handler.releaseHoldLabel)
+	} else {
+		message = fmt.Sprintf("Hold of this pull request on auto-release of '%s' to %s is lifted.", pr.GetBase().GetRef(), strings.Join(autoReleaseEnvironments, ", "))
+	}
+
+	if _, _, err := client.Issues.CreateComment(ctx, repositoryOwner, repositoryName, pr.GetNumber(), &github.IssueComment{Body: &message}); err != nil {
+		return errors.Wrap(err, "commenting release hold on pull request")
+	}
+
+	logger.Info().Msgf("Release hold set to %t for service '%s' in environments %v", hold, serviceName, autoReleaseEnvironments)
+
+	return nil
+}
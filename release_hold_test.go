@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPRCreateHandler_releaseHold(t *testing.T) {
+	tt := []struct {
+		name    string
+		action  string
+		merged  bool
+		labels  []string
+		label   string
+		writes  []string
+		bodies  []string
+		comment string
+	}{
+		{
+			name:   "hold label added",
+			action: "labeled",
+			merged: true,
+			labels: []string{"hold-release"},
+			label:  "hold-release",
+			writes: []string{"POST /holds", "POST /holds"},
+			bodies: []string{
+				`{"id":"pull-request-lunarway-example-2-dev","service":"example","environment":"dev","branch":"master","reason":"Label 'hold-release' on https://github.com/lunarway/example/pull/2","createdBy":"developer"}`,
+				`{"id":"pull-request-lunarway-example-2-prod","service":"example","environment":"prod","branch":"master","reason":"Label 'hold-release' on https://github.com/lunarway/example/pull/2","createdBy":"developer"}`,
+			},
+			comment: "Auto-release of 'master' to dev, prod is on hold because of the label 'hold-release'. Remove the label to lift the hold.",
+		},
+		{
+			name:   "hold label removed",
+			action: "unlabeled",
+			merged: true,
+			label:  "hold-release",
+			writes: []string{"DELETE /holds", "DELETE /holds"},
+			bodies: []string{
+				`{"id":"pull-request-lunarway-example-2-dev","service":"example","environment":"dev","branch":"master"}`,
+				`{"id":"pull-request-lunarway-example-2-prod","service":"example","environment":"prod","branch":"master"}`,
+			},
+			comment: "Hold of this pull request on auto-release of 'master' to dev, prod is lifted.",
+		},
+		{
+			name:   "merged with hold label",
+			action: "closed",
+			merged: true,
+			labels: []string{"hold-release"},
+			writes: []string{"POST /holds", "POST /holds"},
+			bodies: []string{
+				`{"id":"pull-request-lunarway-example-2-dev","service":"example","environment":"dev","branch":"master","reason":"Label 'hold-release' on https://github.com/lunarway/example/pull/2","createdBy":"developer"}`,
+				`{"id":"pull-request-lunarway-example-2-prod","service":"example","environment":"prod","branch":"master","reason":"Label 'hold-release' on https://github.com/lunarway/example/pull/2","createdBy":"developer"}`,
+			},
+			comment: "Auto-release of 'master' to dev, prod is on hold because of the label 'hold-release'. Remove the label to lift the hold.",
+		},
+		{
+			name:   "merged without hold label",
+			action: "closed",
+			merged: true,
+		},
+		{
+			name:   "other label",
+			action: "labeled",
+			merged: true,
+			labels: []string{"bug"},
+			label:  "bug",
+		},
+		{
+			name:   "not merged",
+			action: "labeled",
+			labels: []string{"hold-release"},
+			label:  "hold-release",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, fakeGithub, fakeReleaseManager := newFakeHandler(t, fstest.MapFS{}, exampleReleaseManagerFixtures)
+			handler.releaseHoldLabel = "hold-release"
+			var labels []*github.Label
+			for _, label := range tc.labels {
+				labels = append(labels, &github.Label{Name: github.Ptr(label)})
+			}
+			repository := &github.Repository{
+				Name:     github.Ptr("example"),
+				FullName: github.Ptr("lunarway/example"),
+				Owner:    &github.User{Login: github.Ptr("lunarway")},
+			}
+			event := github.PullRequestEvent{
+				Action: github.Ptr(tc.action),
+				Number: github.Ptr(2),
+				PullRequest: &github.PullRequest{
+					Number:  github.Ptr(2),
+					HTMLURL: github.Ptr("https://github.com/lunarway/example/pull/2"),
+					Merged:  github.Ptr(tc.merged),
+					Labels:  labels,
+					Base:    &github.PullRequestBranch{Ref: github.Ptr("master")},
+					Head:    &github.PullRequestBranch{Ref: github.Ptr("feature"), SHA: github.Ptr("abc")},
+				},
+				Repo:         repository,
+				Sender:       &github.User{Login: github.Ptr("developer")},
+				Installation: &github.Installation{ID: github.Ptr(int64(1))},
+			}
+			if tc.label != "" {
+				event.Label = &github.Label{Name: github.Ptr(tc.label)}
+			}
+			payload, err := json.Marshal(event)
+			require.NoError(t, err)
+
+			err = handler.Handle(zerolog.Nop().WithContext(context.Background()), "pull_request", "delivery", payload)
+
+			require.NoError(t, err)
+			var writes, bodies []string
+			for _, write := range fakeReleaseManager.Writes() {
+				writes = append(writes, write.Method+" "+write.URL)
+				bodies = append(bodies, string(write.Body))
+			}
+			assert.Equal(t, tc.writes, writes, "release-manager writes")
+			for i := range tc.bodies {
+				if assert.Less(t, i, len(bodies)) {
+					assert.JSONEq(t, tc.bodies[i], bodies[i])
+				}
+			}
+			comments := fakeGithub.Comments("lunarway", "example", 2)
+			if tc.comment == "" {
+				assert.Empty(t, comments, "comments")
+				return
+			}
+			require.Len(t, comments, 1, "comments")
+			assert.Equal(t, tc.comment, comments[0].GetBody())
+		})
+	}
+}
+
+func TestReleaseHoldID(t *testing.T) {
+	assert.NotEqual(t, releaseHoldID("lunarway", "example", 1, "prod"), releaseHoldID("lunarway", "example", 2, "prod"), "pull requests")
+	assert.NotEqual(t, releaseHoldID("lunarway", "example", 1, "dev"), releaseHoldID("lunarway", "example", 1, "prod"), "environments")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog"
+)
+
+// releaseManagerEventsHandler receives events from release-manager and
+// refreshes the open pull requests of the affected service when its policies
+// or locks change. Refreshing happens in the background of workers after the
+// event is accepted and is cancelled with ctx, which lives as long as the
+// server.
+func releaseManagerEventsHandler(ctx context.Context, workers *workerGroup, refresh func(ctx context.Context, service string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := zerolog.Ctx(r.Context())
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var event ReleaseManagerEvent
+		err := json.NewDecoder(r.Body).Decode(&event)
+		if err != nil {
+			logger.Info().Msgf("Failed to parse release-manager event: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		eventLogger := logger.With().
+			Str("release_manager_event_type", event.Type).
+			Str("release_manager_service", event.Service).
+			Logger()
+
+		// Filters
+		// - Event type
+		if event.Type != EventTypePolicyChanged && event.Type != EventTypeLockChanged {
+			eventLogger.Info().Msgf("Filter EventType triggered. Type: '%s'", event.Type)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		// - No service
+		if event.Service == "" {
+			eventLogger.Info().Msg("Filter NoService triggered")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		eventLogger.Info().Msgf("Refreshing pull requests of service '%s'", event.Service)
+		started := workers.Go(func() {
+			err := refresh(eventLogger.WithContext(ctx), event.Service)
+			if err != nil {
+				eventLogger.Error().Msgf("Failed to refresh pull requests of service '%s': %v", event.Service, err)
+			}
+		})
+		if !started {
+			eventLogger.Info().Msgf("Refreshing pull requests of service '%s' rejected during shutdown", event.Service)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReleaseManagerEventsHandler(t *testing.T) {
+	tt := []struct {
+		name            string
+		authorization   string
+		body            string
+		expectedStatus  int
+		expectedRefresh string
+	}{
+		{
+			name:            "policy changed",
+			authorization:   "Bearer secret",
+			body:            `{"type":"policy-changed","service":"payments"}`,
+			expectedStatus:  http.StatusAccepted,
+			expectedRefresh: "payments",
+		},
+		{
+			name:           "unauthorized",
+			authorization:  "Bearer wrong",
+			body:           `{"type":"policy-changed","service":"payments"}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "other event type",
+			authorization:  "Bearer secret",
+			body:           `{"type":"release","service":"payments"}`,
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:           "no service",
+			authorization:  "Bearer secret",
+			body:           `{"type":"policy-changed"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid body",
+			authorization:  "Bearer secret",
+			body:           `{`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			refreshed := make(chan string, 1)
+			workers := &workerGroup{}
+			handler := bearerAuthMiddleware("secret", releaseManagerEventsHandler(context.Background(), workers, func(ctx context.Context, service string) error {
+				refreshed <- service
+				return nil
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook/release-manager", strings.NewReader(tc.body))
+			req.Header.Set("Authorization", tc.authorization)
+			w := httptest.NewRecorder()
+
+			// Act
+			handler.ServeHTTP(w, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			assert.NoError(t, workers.Wait(ctx))
+			var service string
+			select {
+			case service = <-refreshed:
+			default:
+			}
+			assert.Equal(t, tc.expectedRefresh, service)
+		})
+	}
+}
+
+func TestReleaseManagerEventsHandler_shutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	workers := &workerGroup{}
+	refreshed := make(chan error, 1)
+	handler := releaseManagerEventsHandler(ctx, workers, func(ctx context.Context, service string) error {
+		<-ctx.Done()
+		refreshed <- ctx.Err()
+		return ctx.Err()
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook/release-manager", strings.NewReader(`{"type":"lock-changed","service":"payments"}`)))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// Shutdown cancels the refresh
+	cancel()
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Second)
+	defer waitCancel()
+	assert.NoError(t, workers.Wait(waitCtx))
+	assert.ErrorIs(t, <-refreshed, context.Canceled)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// findArtifactBySHA returns the artifact built from the commit sha.
+func findArtifactBySHA(sha string, artifacts []Spec) (Spec, bool) {
+	for _, artifact := range artifacts {
+		if artifact.Application.SHA == sha {
+			return artifact, true
+		}
+	}
+	return Spec{}, false
+}
+
+// releaseReadinessCheckRun reports whether release-manager has built an
+// artifact for headSHA. A missing artifact fails the check so it can be used
+// to block merges into auto-release branches. Branches without auto-release
+// are reported as neutral as nothing is released on merge. Environments outside
+// their deployment window are noted as a warning.
+func releaseReadinessCheckRun(name, headSHA string, autoReleaseEnvironments []string, artifacts []Spec, outsideDeploymentWindow []string) github.CreateCheckRunOptions {
+	opts := github.CreateCheckRunOptions{
+		Name:    name,
+		HeadSHA: headSHA,
+		Status:  github.Ptr("completed"),
+	}
+
+	artifact, ok := findArtifactBySHA(headSHA, artifacts)
+	switch {
+	case ok:
+		opts.Conclusion = github.Ptr("success")
+		opts.Output = &github.CheckRunOutput{
+			Title:   github.Ptr(fmt.Sprintf("Artifact %s is ready", artifact.ID)),
+			Summary: github.Ptr(fmt.Sprintf("release-manager has built artifact '%s' for commit %s.", artifact.ID, headSHA)),
+		}
+		if artifact.CI.JobURL != "" {
+			opts.DetailsURL = github.Ptr(artifact.CI.JobURL)
+		}
+	case len(autoReleaseEnvironments) == 0:
+		opts.Conclusion = github.Ptr("neutral")
+		opts.Output = &github.CheckRunOutput{
+			Title:   github.Ptr("No auto-release"),
+			Summary: github.Ptr(fmt.Sprintf("No artifact is built for commit %s yet, but merging does not trigger an auto-release.", headSHA)),
+		}
+	default:
+		opts.Conclusion = github.Ptr("failure")
+		opts.Output = &github.CheckRunOutput{
+			Title:   github.Ptr("No artifact built"),
+			Summary: github.Ptr(fmt.Sprintf("release-manager has not built an artifact for commit %s, which will auto-release to: %s. Re-run this check when the artifact pipeline has succeeded.", headSHA, strings.Join(autoReleaseEnvironments, ", "))),
+		}
+	}
+	if warning := deploymentWindowWarning(outsideDeploymentWindow); warning != "" {
+		opts.Output.Text = github.Ptr(":warning: " + warning)
+	}
+
+	return opts
+}
+
+// createCheckRun creates the check run. With onlyIfChanged it is only created
+// if its conclusion or output differs from the latest check run with the same
+// name. It reports whether the check run was created.
+func (handler *PRCreateHandler) createCheckRun(ctx context.Context, client *github.Client, owner, repo string, opts github.CreateCheckRunOptions, onlyIfChanged bool) (bool, error) {
+	logger := zerolog.Ctx(ctx)
+
+	if onlyIfChanged {
+		checkRuns, _, err := client.Checks.ListCheckRunsForRef(ctx, owner, repo, opts.HeadSHA, &github.ListCheckRunsOptions{
+			CheckName: github.Ptr(opts.Name),
+			Filter:    github.Ptr("latest"),
+		})
+		if err != nil {
+			return false, errors.Wrapf(err, "listing check runs '%s' for commit '%s'", opts.Name, opts.HeadSHA)
+		}
+		if len(checkRuns.CheckRuns) > 0 {
+			latest := checkRuns.CheckRuns[0]
+			if latest.GetConclusion() == opts.GetConclusion() &&
+				latest.GetOutput().GetTitle() == opts.GetOutput().GetTitle() &&
+				latest.GetOutput().GetText() == opts.GetOutput().GetText() {
+				logger.Debug().Msgf("Check run '%s' for commit %s is unchanged", opts.Name, opts.HeadSHA)
+				return false, nil
+			}
+		}
+	}
+
+	if _, _, err := client.Checks.CreateCheckRun(ctx, owner, repo, opts); err != nil {
+		return false, errors.Wrapf(err, "creating check run '%s' for commit '%s'", opts.Name, opts.HeadSHA)
+	}
+	logger.Info().Msgf("Check run '%s' created for commit %s with conclusion '%s'", opts.Name, opts.HeadSHA, opts.GetConclusion())
+	return true, nil
+}
+
+// handleCheckRun re-evaluates the release readiness check when it is re-run
+// from GitHub, e.g. after the artifact pipeline has succeeded.
+func (handler *PRCreateHandler) handleCheckRun(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	var event github.CheckRunEvent
+
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return errors.Wrap(err, "parsing payload")
+	}
+
+	repository := event.GetRepo()
+	installationID := githubapp.GetInstallationIDFromEvent(&event)
+	checkRun := event.GetCheckRun()
+
+	logger := zerolog.Ctx(ctx).With().
+		Int64("github_installation_id", installationID).
+		Str("github_repository_owner", repository.GetOwner().GetLogin()).
+		Str("github_repository_name", repository.GetName()).
+		Str("github_check_run_name", checkRun.GetName()).
+		Logger()
+	ctx = logger.WithContext(ctx)
+
+	logger.Info().Msgf("Handling deliveryID: '%s', eventType '%s'", deliveryID, eventType)
+
+	// Filters
+	// - Disabled
+	if checkFilter(ctx, "ReleaseReadinessDisabled", func() bool {
+		if handler.releaseReadinessCheck {
+			return false
+		}
+		logger.Info().Msg("Filter ReleaseReadinessDisabled triggered")
+		return true
+	}) {
+		return nil
+	}
+	// - Action type
+	if checkFilter(ctx, "ActionType", func() bool {
+		if event.GetAction() == "rerequested" {
+			return false
+		}
+		logger.Info().Msgf("Filter ActionType triggered. Action: '%s'", event.GetAction())
+		return true
+	}) {
+		return nil
+	}
+	// - Other checks
+	if checkFilter(ctx, "CheckName", func() bool {
+		if checkRun.GetName() == handler.releaseReadinessCheckName {
+			return false
+		}
+		logger.Info().Msgf("Filter CheckName triggered. Name: '%s'", checkRun.GetName())
+		return true
+	}) {
+		return nil
+	}
+	// - Ignored repositories
+	if checkFilter(ctx, "IgnoredRepo", func() bool {
+		if !any(handler.repoFilters, func(filterRepo string) bool {
+			return filterRepo == repository.GetName()
+		}) {
+			return false
+		}
+		logger.Info().Msgf("Filter IgnoredRepo triggered. Repo: '%s'", repository.GetName())
+		return true
+	}) {
+		return nil
+	}
+
+	serviceName := getServiceName(repository.GetName(), handler.config(ctx).RepoToServiceMap)
+
+	artifacts, err := handler.retrieveArtifacts(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+
+	policies, err := handler.retrievePolicies(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+
+	// A check run may belong to several pull requests; it is enough that one
+	// of them targets an auto-release branch.
+	var autoReleaseEnvironments []string
+	for _, pr := range checkRun.PullRequests {
+		autoReleaseEnvironments = append(autoReleaseEnvironments, matchAutoReleases(policies.AutoReleases, pr.GetBase().GetRef())...)
+	}
+
+	client, err := handler.NewInstallationClient(installationID)
+	if err != nil {
+		return errors.Wrapf(err, "creating new github.Client from installation id '%d'", installationID)
+	}
+
+	outside := handler.config(ctx).DeploymentWindows.outside(autoReleaseEnvironments, time.Now())
+	opts := releaseReadinessCheckRun(handler.releaseReadinessCheckName, checkRun.GetHeadSHA(), autoReleaseEnvironments, artifacts, outside)
+	_, err = handler.createCheckRun(ctx, client, repository.GetOwner().GetLogin(), repository.GetName(), opts, false)
+	return err
+}
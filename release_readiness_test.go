@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReleaseReadinessCheckRun(t *testing.T) {
+	artifacts := []Spec{
+		{
+			ID:          "master-1234567890-42",
+			Application: Repository{SHA: "1234567890"},
+			CI:          CI{JobURL: "https://jenkins/job/42"},
+		},
+	}
+
+	tt := []struct {
+		name                    string
+		headSHA                 string
+		autoReleaseEnvironments []string
+		outsideDeploymentWindow []string
+		expectedConclusion      string
+		expectedDetailsURL      string
+		expectedText            string
+	}{
+		{
+			name:                    "artifact built",
+			headSHA:                 "1234567890",
+			autoReleaseEnvironments: []string{"dev"},
+			expectedConclusion:      "success",
+			expectedDetailsURL:      "https://jenkins/job/42",
+		},
+		{
+			name:                    "artifact missing for auto-release branch",
+			headSHA:                 "abcdef",
+			autoReleaseEnvironments: []string{"dev"},
+			expectedConclusion:      "failure",
+		},
+		{
+			name:                    "artifact built outside deployment window",
+			headSHA:                 "1234567890",
+			autoReleaseEnvironments: []string{"dev", "prod"},
+			outsideDeploymentWindow: []string{"prod"},
+			expectedConclusion:      "success",
+			expectedDetailsURL:      "https://jenkins/job/42",
+			expectedText:            ":warning: Merging now auto-releases outside the deployment window of prod",
+		},
+		{
+			name:               "artifact missing without auto-release",
+			headSHA:            "abcdef",
+			expectedConclusion: "neutral",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			opts := releaseReadinessCheckRun("release-manager/artifact", tc.headSHA, tc.autoReleaseEnvironments, artifacts, tc.outsideDeploymentWindow)
+
+			// Assert
+			assert.Equal(t, "release-manager/artifact", opts.Name)
+			assert.Equal(t, tc.headSHA, opts.HeadSHA)
+			assert.Equal(t, "completed", opts.GetStatus())
+			assert.Equal(t, tc.expectedConclusion, opts.GetConclusion())
+			assert.Equal(t, tc.expectedDetailsURL, opts.GetDetailsURL())
+			assert.Equal(t, tc.expectedText, opts.GetOutput().GetText())
+		})
+	}
+}
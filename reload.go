@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/spf13/pflag"
+)
+
+// ReloadableConfig is the configuration that can change without a restart,
+// including secrets rotated by replacing the files they are read from.
+type ReloadableConfig struct {
+	MessageTemplate       string
+	RepoToServiceMap      map[string]string
+	SquadToTeamMap        map[string]string
+	SensitiveEnvironments []string
+	DeploymentWindows     DeploymentWindows
+
+	ReleaseManagerAuthToken string
+	GithubWebhookSecrets    []string
+	GithubPrivateKey        string
+}
+
+// reloadableConfigFromFlags builds and validates the reloadable configuration
+// from parsed flags. It is used both at startup and when reloading.
+func reloadableConfigFromFlags(flags *pflag.FlagSet, readFile func(string) ([]byte, error)) (*ReloadableConfig, error) {
+	var config ReloadableConfig
+	var err error
+
+	config.ReleaseManagerAuthToken, err = flags.GetString("release-manager-auth-token")
+	if err != nil {
+		return nil, err
+	}
+	if config.ReleaseManagerAuthToken == "" {
+		return nil, errors.New("flag 'release-manager-auth-token' is empty")
+	}
+	webhookSecret, err := flags.GetString("github-webhook-secret")
+	if err != nil {
+		return nil, err
+	}
+	config.GithubWebhookSecrets = splitSecrets(webhookSecret)
+	config.GithubPrivateKey, err = flags.GetString("github-private-key")
+	if err != nil {
+		return nil, err
+	}
+	if config.GithubPrivateKey != "" {
+		err = validatePrivateKey(config.GithubPrivateKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "flag 'github-private-key' error recieved")
+		}
+	}
+
+	config.MessageTemplate, err = flags.GetString("message-template")
+	if err != nil {
+		return nil, err
+	}
+	templateFile, err := flags.GetString("message-template-file")
+	if err != nil {
+		return nil, err
+	}
+	if templateFile != "" {
+		if flags.Changed("message-template") {
+			return nil, errors.New("both 'message-template' and 'message-template-file' are set")
+		}
+		content, err := readFile(templateFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading 'message-template-file'")
+		}
+		config.MessageTemplate = string(content)
+	}
+	err = validateMessageTemplate(config.MessageTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "flag 'message-template' parsing error recieved")
+	}
+
+	windowsFile, err := flags.GetString("deployment-windows-file")
+	if err != nil {
+		return nil, err
+	}
+	if windowsFile != "" {
+		config.DeploymentWindows, err = loadDeploymentWindows(windowsFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "flag 'deployment-windows-file' error recieved")
+		}
+	}
+
+	config.RepoToServiceMap, err = flags.GetStringToString("map-repo-to-service")
+	if err != nil {
+		return nil, err
+	}
+	config.SquadToTeamMap, err = flags.GetStringToString("map-squad-to-team")
+	if err != nil {
+		return nil, err
+	}
+	config.SensitiveEnvironments, err = flags.GetStringSlice("sensitive-environments")
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// reloadFlagSet returns an unparsed copy of flags to load the configuration
+// into again. Strings, string slices and string maps keep their type. Other
+// flags keep their last value like their pflag types do.
+func reloadFlagSet(flags *pflag.FlagSet) *pflag.FlagSet {
+	reload := pflag.NewFlagSet(flags.Name(), pflag.ContinueOnError)
+	reload.SetOutput(&strings.Builder{})
+	flags.VisitAll(func(f *pflag.Flag) {
+		// Defaults of lists and maps are formatted like '[a,b]'
+		var defaults []string
+		if defaultValue := strings.Trim(f.DefValue, "[]"); defaultValue != "" {
+			defaults = strings.Split(defaultValue, ",")
+		}
+		switch f.Value.Type() {
+		case "string":
+			reload.String(f.Name, f.DefValue, f.Usage)
+		case "stringSlice":
+			reload.StringSlice(f.Name, defaults, f.Usage)
+		case "stringToString":
+			defaultMap := make(map[string]string, len(defaults))
+			for _, pair := range defaults {
+				key, value, _ := strings.Cut(pair, "=")
+				defaultMap[key] = value
+			}
+			reload.StringToString(f.Name, defaultMap, f.Usage)
+		default:
+			reload.Var(&scalarValue{value: f.DefValue, typ: f.Value.Type()}, f.Name, f.Usage)
+		}
+		reloadFlag := reload.Lookup(f.Name)
+		reloadFlag.Shorthand = f.Shorthand
+		reloadFlag.NoOptDefVal = f.NoOptDefVal
+		reloadFlag.DefValue = f.DefValue
+	})
+	return reload
+}
+
+// scalarValue stores the last value a flag is set to without parsing it.
+type scalarValue struct {
+	value string
+	typ   string
+}
+
+func (v *scalarValue) String() string {
+	return v.value
+}
+
+func (v *scalarValue) Set(value string) error {
+	v.value = value
+	return nil
+}
+
+func (v *scalarValue) Type() string {
+	return v.typ
+}
+
+// ConfigReloader reloads the reloadable configuration on SIGHUP and when the
+// content of a watched file changes. A configuration failing validation is
+// discarded and the current one kept.
+type ConfigReloader struct {
+	load     func() (*ReloadableConfig, error)
+	apply    func(*ReloadableConfig)
+	files    []string
+	interval time.Duration
+	logger   zerolog.Logger
+
+	mu     sync.Mutex
+	hashes map[string][sha256.Size]byte
+
+	metricReloads     *prometheus.CounterVec
+	metricLastSuccess prometheus.Gauge
+	metricLastFailed  prometheus.Gauge
+}
+
+// NewConfigReloader creates a ConfigReloader checking files for changes every
+// interval. Watching files is disabled if interval is 0.
+func NewConfigReloader(load func() (*ReloadableConfig, error), apply func(*ReloadableConfig), files []string, interval time.Duration, logger zerolog.Logger, promRegisterer prometheus.Registerer) *ConfigReloader {
+	r := &ConfigReloader{
+		load:     load,
+		apply:    apply,
+		interval: interval,
+		logger:   logger,
+		hashes:   make(map[string][sha256.Size]byte),
+		metricReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "Counter of configuration reloads by result",
+		}, []string{"result"}),
+		metricLastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "config_last_reload_success_timestamp_seconds",
+			Help: "Gauge of the unix time of the last successful configuration reload",
+		}),
+		metricLastFailed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "config_last_reload_failed_info",
+			Help: "Gauge that is 1 if the last configuration reload failed and the previous configuration is in use",
+		}),
+	}
+	for _, file := range files {
+		if file != "" {
+			r.files = append(r.files, file)
+		}
+	}
+	r.changed()
+
+	promRegisterer.MustRegister(r.metricReloads)
+	promRegisterer.MustRegister(r.metricLastSuccess)
+	promRegisterer.MustRegister(r.metricLastFailed)
+
+	return r
+}
+
+// Run reloads on signals from sighup and on file changes until ctx is
+// cancelled.
+func (r *ConfigReloader) Run(ctx context.Context, sighup <-chan os.Signal) {
+	var tick <-chan time.Time
+	if r.interval > 0 && len(r.files) > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sighup:
+			r.logger.Info().Msg("Reloading configuration on SIGHUP")
+			r.changed()
+		case <-tick:
+			if !r.changed() {
+				continue
+			}
+			r.logger.Info().Msg("Reloading configuration on file change")
+		}
+		err := r.Reload()
+		if err != nil {
+			r.logger.Error().Msgf("Failed to reload configuration; keeping the current configuration: %v", err)
+		}
+	}
+}
+
+// Reload loads, validates and applies the configuration.
+func (r *ConfigReloader) Reload() error {
+	config, err := r.load()
+	if err != nil {
+		r.metricReloads.WithLabelValues("failure").Inc()
+		r.metricLastFailed.Set(1)
+		return err
+	}
+	r.apply(config)
+	r.metricReloads.WithLabelValues("success").Inc()
+	r.metricLastFailed.Set(0)
+	r.metricLastSuccess.SetToCurrentTime()
+	r.logger.Info().Msg("Configuration reloaded")
+	return nil
+}
+
+// changed reports whether the content of any watched file or directory
+// changed since the last call. Files that cannot be read are left for Reload
+// to report.
+func (r *ConfigReloader) changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := false
+	for _, file := range r.files {
+		hash, err := hashWatched(file)
+		if err != nil {
+			continue
+		}
+		if previous, ok := r.hashes[file]; !ok || previous != hash {
+			changed = true
+		}
+		r.hashes[file] = hash
+	}
+	return changed
+}
+
+// hashWatched hashes the content of file or, if it is a directory, the names
+// and content of the files in it. Directories are watched as a whole as
+// mounted configuration is often replaced by swapping symlinks in them.
+func hashWatched(file string) ([sha256.Size]byte, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	if !info.IsDir() {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		return sha256.Sum256(content), nil
+	}
+
+	entries, err := os.ReadDir(file)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	hash := sha256.New()
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(file, entry.Name()))
+		if err != nil {
+			// Sub directories are not watched
+			continue
+		}
+		contentHash := sha256.Sum256(content)
+		hash.Write([]byte(entry.Name()))
+		hash.Write(contentHash[:])
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/spf13/pflag"
+)
+
+// Synthetic events
+const (
+	SyntheticEventOpened      = "opened"
+	SyntheticEventBaseChanged = "base-changed"
+	SyntheticEventMerged      = "merged"
+)
+
+// replayOptions configures the replay subcommand.
+type replayOptions struct {
+	URL            string
+	InProcess      bool
+	EventType      string
+	Synthetic      string
+	Repository     string
+	PullRequest    int
+	Base           string
+	PreviousBase   string
+	Head           string
+	HeadSHA        string
+	InstallationID int64
+}
+
+// addReplayFlags adds the flags of the replay subcommand to flags.
+func addReplayFlags(flags *pflag.FlagSet) *replayOptions {
+	var options replayOptions
+	flags.StringVar(&options.URL, "replay-url", "", "URL of a running bot to post webhooks to. Defaults to the Github webhook route on localhost and --http-port")
+	flags.BoolVar(&options.InProcess, "replay-in-process", false, "Handle webhooks in-process instead of posting them to a running bot")
+	flags.StringVar(&options.EventType, "replay-event-type", "", "Github event type of payload files. Detected from the payload if empty")
+	flags.StringVar(&options.Synthetic, "replay-synthetic", "", "Replay a generated pull request event instead of payload files. One of 'opened', 'base-changed' or 'merged'")
+	flags.StringVar(&options.Repository, "replay-repository", "lunarway/example", "Repository of the synthetic event as owner/name")
+	flags.IntVar(&options.PullRequest, "replay-pull-request", 1, "Pull request number of the synthetic event")
+	flags.StringVar(&options.Base, "replay-base", "master", "Base branch of the synthetic event")
+	flags.StringVar(&options.PreviousBase, "replay-previous-base", "develop", "Base branch before a synthetic 'base-changed' event")
+	flags.StringVar(&options.Head, "replay-head", "feature", "Head branch of the synthetic event")
+	flags.StringVar(&options.HeadSHA, "replay-head-sha", "0000000000000000000000000000000000000000", "Head commit of the synthetic event")
+	flags.Int64Var(&options.InstallationID, "replay-installation-id", 0, "Github app installation ID of the synthetic event")
+	return &options
+}
+
+// replayPayload is a webhook payload to replay. A new delivery ID is used
+// unless DeliveryID is set.
+type replayPayload struct {
+	Name       string
+	EventType  string
+	DeliveryID string
+	Body       []byte
+}
+
+// payloads returns the synthetic event or the payloads of paths. Directories
+// are replayed in lexical order of their '.json' files. Deliveries archived
+// by PayloadArchive are replayed with their event type and delivery ID.
+func (options replayOptions) payloads(paths []string) ([]replayPayload, error) {
+	if options.Synthetic != "" {
+		if len(paths) > 0 {
+			return nil, errors.New("payload files cannot be replayed with a synthetic event")
+		}
+		body, err := options.syntheticPullRequestEvent()
+		if err != nil {
+			return nil, err
+		}
+		return []replayPayload{{Name: "synthetic " + options.Synthetic, EventType: "pull_request", Body: body}}, nil
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("no payload files")
+	}
+
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	var payloads []replayPayload
+	for _, file := range files {
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var archived ArchivedDelivery
+		if json.Unmarshal(body, &archived) == nil && archived.Event != "" && len(archived.Payload) > 0 {
+			payloads = append(payloads, replayPayload{Name: file, EventType: archived.Event, DeliveryID: archived.DeliveryID, Body: archived.Payload})
+			continue
+		}
+		eventType := options.EventType
+		if eventType == "" {
+			eventType, err = detectEventType(body)
+			if err != nil {
+				return nil, errors.Wrapf(err, "payload '%s'", file)
+			}
+		}
+		payloads = append(payloads, replayPayload{Name: file, EventType: eventType, Body: body})
+	}
+	return payloads, nil
+}
+
+// detectEventType returns the Github event type of the events handled by the
+// bot from the fields of their payload.
+func detectEventType(body []byte) (string, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(body, &fields)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing payload")
+	}
+	switch {
+	case fields["check_run"] != nil:
+		return "check_run", nil
+	case fields["comment"] != nil && fields["issue"] != nil:
+		return "issue_comment", nil
+	case fields["pull_request"] != nil:
+		return "pull_request", nil
+	default:
+		return "", errors.New("unknown event type; set --replay-event-type")
+	}
+}
+
+// syntheticPullRequestEvent generates the payload of a pull request being
+// opened, changing its base branch or being merged.
+func (options replayOptions) syntheticPullRequestEvent() ([]byte, error) {
+	owner, name, ok := strings.Cut(options.Repository, "/")
+	if !ok || owner == "" || name == "" {
+		return nil, errors.Errorf("repository '%s' is not formatted as owner/name", options.Repository)
+	}
+	now := github.Timestamp{Time: time.Now().UTC().Truncate(time.Second)}
+	repository := &github.Repository{
+		Name:          github.Ptr(name),
+		FullName:      github.Ptr(options.Repository),
+		Owner:         &github.User{Login: github.Ptr(owner)},
+		DefaultBranch: github.Ptr("master"),
+		HTMLURL:       github.Ptr("https://github.com/" + options.Repository),
+	}
+	sender := &github.User{Login: github.Ptr("release-manager-bot-replay")}
+	pullRequest := &github.PullRequest{
+		Number:    github.Ptr(options.PullRequest),
+		State:     github.Ptr("open"),
+		Title:     github.Ptr("Replayed pull request"),
+		HTMLURL:   github.Ptr(fmt.Sprintf("https://github.com/%s/pull/%d", options.Repository, options.PullRequest)),
+		User:      sender,
+		CreatedAt: &now,
+		UpdatedAt: &now,
+		Base:      &github.PullRequestBranch{Ref: github.Ptr(options.Base), Repo: repository},
+		Head:      &github.PullRequestBranch{Ref: github.Ptr(options.Head), SHA: github.Ptr(options.HeadSHA), Repo: repository},
+	}
+	event := github.PullRequestEvent{
+		Number:       github.Ptr(options.PullRequest),
+		PullRequest:  pullRequest,
+		Repo:         repository,
+		Sender:       sender,
+		Installation: &github.Installation{ID: github.Ptr(options.InstallationID)},
+	}
+
+	switch options.Synthetic {
+	case SyntheticEventOpened:
+		event.Action = github.Ptr("opened")
+	case SyntheticEventBaseChanged:
+		event.Action = github.Ptr("edited")
+		event.Changes = &github.EditChange{
+			Base: &github.EditBase{
+				Ref: &github.EditRef{From: github.Ptr(options.PreviousBase)},
+				SHA: &github.EditSHA{From: github.Ptr(options.HeadSHA)},
+			},
+		}
+	case SyntheticEventMerged:
+		event.Action = github.Ptr("closed")
+		pullRequest.State = github.Ptr("closed")
+		pullRequest.Merged = github.Ptr(true)
+		pullRequest.MergedAt = &now
+		pullRequest.ClosedAt = &now
+		pullRequest.MergeCommitSHA = github.Ptr(options.HeadSHA)
+	default:
+		return nil, errors.Errorf("unknown synthetic event '%s'; expected one of '%s', '%s' or '%s'", options.Synthetic, SyntheticEventOpened, SyntheticEventBaseChanged, SyntheticEventMerged)
+	}
+	return json.MarshalIndent(event, "", "  ")
+}
+
+// signWebhookPayload returns the SHA-256 and SHA-1 signature headers of body
+// as Github computes them with secret.
+func signWebhookPayload(secret string, body []byte) (string, string) {
+	sha256Mac := hmac.New(sha256.New, []byte(secret))
+	sha256Mac.Write(body)
+	sha1Mac := hmac.New(sha1.New, []byte(secret))
+	sha1Mac.Write(body)
+	return "sha256=" + hex.EncodeToString(sha256Mac.Sum(nil)), "sha1=" + hex.EncodeToString(sha1Mac.Sum(nil))
+}
+
+// newDeliveryID returns a random delivery ID formatted as the UUIDs Github
+// uses.
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// newReplayRequest creates a webhook request for payload with the headers set
+// by Github. The payload is signed with secret unless it is empty.
+func newReplayRequest(ctx context.Context, url, secret string, payload replayPayload) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(string(payload.Body)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("User-Agent", "GitHub-Hookshot/release-manager-bot-replay")
+	deliveryID := payload.DeliveryID
+	if deliveryID == "" {
+		deliveryID = newDeliveryID()
+	}
+	req.Header.Set("X-GitHub-Delivery", deliveryID)
+	req.Header.Set("X-GitHub-Event", payload.EventType)
+	req.Header.Set("X-GitHub-Hook-Installation-Target-Type", "integration")
+	if secret != "" {
+		signature256, signature := signWebhookPayload(secret, payload.Body)
+		req.Header.Set(github.SHA256SignatureHeader, signature256)
+		req.Header.Set(github.SHA1SignatureHeader, signature)
+	}
+	return req, nil
+}
+
+// inProcess sends requests to handler without a server.
+func inProcess(handler http.Handler) func(*http.Request) (*http.Response, error) {
+	return func(r *http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Result(), nil
+	}
+}
+
+// replay sends payloads to url signed with secret and logs the responses. An
+// error is returned if any payload is not accepted.
+func replay(ctx context.Context, url, secret string, payloads []replayPayload, send func(*http.Request) (*http.Response, error)) error {
+	logger := zerolog.Ctx(ctx)
+	failed := 0
+	for _, payload := range payloads {
+		req, err := newReplayRequest(ctx, url, secret, payload)
+		if err != nil {
+			return errors.Wrapf(err, "creating request for payload '%s'", payload.Name)
+		}
+		deliveryID := req.Header.Get("X-GitHub-Delivery")
+
+		res, err := send(req)
+		if err != nil {
+			logger.Error().Msgf("Failed to replay payload '%s' as delivery '%s': %v", payload.Name, deliveryID, err)
+			failed++
+			continue
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			logger.Error().Msgf("Payload '%s' replayed as delivery '%s' failed with status code %d: %s", payload.Name, deliveryID, res.StatusCode, strings.TrimSpace(string(body)))
+			failed++
+			continue
+		}
+		logger.Info().Msgf("Payload '%s' replayed as %s delivery '%s' with status code %d", payload.Name, payload.EventType, deliveryID, res.StatusCode)
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d payloads failed", failed, len(payloads))
+	}
+	return nil
+}
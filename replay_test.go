@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectEventType(t *testing.T) {
+	tt := []struct {
+		name      string
+		payload   string
+		eventType string
+		err       string
+	}{
+		{
+			name:      "pull request",
+			payload:   `{"action":"opened","number":1,"pull_request":{"number":1}}`,
+			eventType: "pull_request",
+		},
+		{
+			name:      "check run",
+			payload:   `{"action":"rerequested","check_run":{"id":1,"pull_requests":[]}}`,
+			eventType: "check_run",
+		},
+		{
+			name:      "issue comment",
+			payload:   `{"action":"created","issue":{"number":1,"pull_request":{}},"comment":{"body":"/release-manager explain"}}`,
+			eventType: "issue_comment",
+		},
+		{
+			name:    "unknown",
+			payload: `{"action":"created"}`,
+			err:     "unknown event type",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			eventType, err := detectEventType([]byte(tc.payload))
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.eventType, eventType)
+		})
+	}
+}
+
+func TestSyntheticPullRequestEvent(t *testing.T) {
+	options := replayOptions{
+		Repository:     "lunarway/example",
+		PullRequest:    42,
+		Base:           "master",
+		PreviousBase:   "develop",
+		Head:           "feature",
+		HeadSHA:        "abc",
+		InstallationID: 7,
+	}
+	tt := []struct {
+		synthetic    string
+		action       string
+		merged       bool
+		previousBase string
+	}{
+		{synthetic: SyntheticEventOpened, action: "opened"},
+		{synthetic: SyntheticEventBaseChanged, action: "edited", previousBase: "develop"},
+		{synthetic: SyntheticEventMerged, action: "closed", merged: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.synthetic, func(t *testing.T) {
+			options.Synthetic = tc.synthetic
+			body, err := options.syntheticPullRequestEvent()
+			require.NoError(t, err)
+
+			var event github.PullRequestEvent
+			require.NoError(t, json.Unmarshal(body, &event))
+			assert.Equal(t, tc.action, event.GetAction(), "action")
+			assert.Equal(t, 42, event.GetNumber(), "number")
+			assert.Equal(t, "example", event.GetRepo().GetName(), "repository name")
+			assert.Equal(t, "lunarway", event.GetRepo().GetOwner().GetLogin(), "repository owner")
+			assert.Equal(t, int64(7), event.GetInstallation().GetID(), "installation")
+			assert.Equal(t, "master", event.GetPullRequest().GetBase().GetRef(), "base")
+			assert.Equal(t, "abc", event.GetPullRequest().GetHead().GetSHA(), "head")
+			assert.Equal(t, tc.merged, event.GetPullRequest().GetMerged(), "merged")
+			assert.Equal(t, tc.previousBase, event.GetChanges().GetBase().GetRef().GetFrom(), "previous base")
+		})
+	}
+
+	options.Synthetic = "synchronize"
+	_, err := options.syntheticPullRequestEvent()
+	assert.Error(t, err)
+}
+
+func TestReplayOptions_payloads(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2-edited.json"), []byte(`{"action":"edited","pull_request":{}}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1-opened.json"), []byte(`{"action":"opened","pull_request":{}}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a payload"), 0o600))
+
+	payloads, err := replayOptions{}.payloads([]string{dir})
+	require.NoError(t, err)
+	require.Len(t, payloads, 2)
+	assert.Equal(t, filepath.Join(dir, "1-opened.json"), payloads[0].Name)
+	assert.Equal(t, filepath.Join(dir, "2-edited.json"), payloads[1].Name)
+	assert.Equal(t, "pull_request", payloads[1].EventType)
+
+	payloads, err = replayOptions{EventType: "check_run"}.payloads([]string{filepath.Join(dir, "1-opened.json")})
+	require.NoError(t, err)
+	assert.Equal(t, "check_run", payloads[0].EventType)
+
+	_, err = replayOptions{Synthetic: SyntheticEventOpened, Repository: "lunarway/example"}.payloads([]string{dir})
+	assert.Error(t, err, "synthetic event with payload files")
+
+	_, err = replayOptions{}.payloads(nil)
+	assert.Error(t, err, "no payloads")
+}
+
+func TestReplay(t *testing.T) {
+	payloads := []replayPayload{
+		{Name: "accepted", EventType: "pull_request", Body: []byte(`{"action":"opened"}`)},
+		{Name: "rejected", EventType: "pull_request", Body: []byte(`{"action":"edited"}`)},
+	}
+	var deliveries []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, err := github.ValidatePayload(r, []byte("secret"))
+		require.NoError(t, err, "signature")
+		assert.Equal(t, "pull_request", github.WebHookType(r))
+		assert.NotEmpty(t, github.DeliveryID(r))
+		deliveries = append(deliveries, github.DeliveryID(r))
+		if string(payload) == `{"action":"edited"}` {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	err := replay(context.Background(), "http://localhost/webhook/github/bot", "secret", payloads, inProcess(handler))
+
+	require.Error(t, err)
+	assert.Equal(t, "1 of 2 payloads failed", err.Error())
+	require.Len(t, deliveries, 2)
+	assert.NotEqual(t, deliveries[0], deliveries[1], "unique delivery IDs")
+}
+
+func TestReplay_webhookSignatureMiddleware(t *testing.T) {
+	payloads := []replayPayload{{Name: "opened", EventType: "pull_request", Body: []byte(`{"action":"opened"}`)}}
+	handler := webhookSignatureMiddleware(func() []string { return []string{"new", "old"} }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	assert.NoError(t, replay(context.Background(), "http://localhost/webhook/github/bot", "old", payloads, inProcess(handler)))
+	assert.Error(t, replay(context.Background(), "http://localhost/webhook/github/bot", "other", payloads, inProcess(handler)))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/shurcooL/githubv4"
+	"golang.org/x/oauth2"
+)
+
+// splitSecrets returns the secrets of a value with one secret per line.
+func splitSecrets(value string) []string {
+	var secrets []string
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			secrets = append(secrets, line)
+		}
+	}
+	return secrets
+}
+
+// validatePrivateKey verifies that key is a PEM encoded PKCS1 or PKCS8 RSA
+// private key as required by Github apps.
+func validatePrivateKey(key string) error {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return errors.New("no PEM data found")
+	}
+	_, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err == nil {
+		return nil
+	}
+	_, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return errors.Wrap(err, "parsing private key")
+	}
+	return nil
+}
+
+// changedSecrets returns the names of the secrets that differ between two
+// configurations.
+func changedSecrets(previous, next *ReloadableConfig) []string {
+	var changed []string
+	if previous.GithubPrivateKey != next.GithubPrivateKey {
+		changed = append(changed, "github-private-key")
+	}
+	if strings.Join(previous.GithubWebhookSecrets, "\n") != strings.Join(next.GithubWebhookSecrets, "\n") {
+		changed = append(changed, "github-webhook-secret")
+	}
+	if previous.ReleaseManagerAuthToken != next.ReleaseManagerAuthToken {
+		changed = append(changed, "release-manager-auth-token")
+	}
+	return changed
+}
+
+// webhookSignatureMiddleware only lets Github webhooks signed with any of the
+// secrets through to h. Signature headers are removed from verified requests,
+// so h must not verify them again. Requests are not verified if there are no
+// secrets.
+func webhookSignatureMiddleware(secrets func() []string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentSecrets := secrets()
+		if len(currentSecrets) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		logger := zerolog.Ctx(r.Context())
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Info().Msgf("Failed to read webhook body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+
+		signature := r.Header.Get(github.SHA256SignatureHeader)
+		if signature == "" {
+			signature = r.Header.Get(github.SHA1SignatureHeader)
+		}
+		matched := -1
+		for i, secret := range currentSecrets {
+			if github.ValidateSignature(signature, body, []byte(secret)) == nil {
+				matched = i
+				break
+			}
+		}
+		if matched < 0 {
+			logger.Info().Msgf("Webhook signature matches none of %d secrets", len(currentSecrets))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if matched > 0 {
+			logger.Debug().Msgf("Webhook signature matches secret %d of %d", matched+1, len(currentSecrets))
+		}
+
+		r.Header.Del(github.SHA256SignatureHeader)
+		r.Header.Del(github.SHA1SignatureHeader)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// reloadingClientCreator is a githubapp.ClientCreator that is recreated when
+// the private key of the Github app changes. Clients created before a change
+// keep the previous key.
+type reloadingClientCreator struct {
+	newClientCreator func(privateKey string) (githubapp.ClientCreator, error)
+
+	mu         sync.RWMutex
+	privateKey string
+	current    githubapp.ClientCreator
+}
+
+// newReloadingClientCreator creates a reloadingClientCreator using privateKey.
+func newReloadingClientCreator(privateKey string, newClientCreator func(privateKey string) (githubapp.ClientCreator, error)) (*reloadingClientCreator, error) {
+	current, err := newClientCreator(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &reloadingClientCreator{
+		newClientCreator: newClientCreator,
+		privateKey:       privateKey,
+		current:          current,
+	}, nil
+}
+
+// SetPrivateKey recreates the client creator if privateKey differs from the
+// current key.
+func (c *reloadingClientCreator) SetPrivateKey(privateKey string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if privateKey == c.privateKey {
+		return nil
+	}
+	current, err := c.newClientCreator(privateKey)
+	if err != nil {
+		return err
+	}
+	c.privateKey = privateKey
+	c.current = current
+	return nil
+}
+
+func (c *reloadingClientCreator) clientCreator() githubapp.ClientCreator {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.current
+}
+
+func (c *reloadingClientCreator) NewAppClient() (*github.Client, error) {
+	return c.clientCreator().NewAppClient()
+}
+
+func (c *reloadingClientCreator) NewAppV4Client() (*githubv4.Client, error) {
+	return c.clientCreator().NewAppV4Client()
+}
+
+func (c *reloadingClientCreator) NewInstallationClient(installationID int64) (*github.Client, error) {
+	return c.clientCreator().NewInstallationClient(installationID)
+}
+
+func (c *reloadingClientCreator) NewInstallationV4Client(installationID int64) (*githubv4.Client, error) {
+	return c.clientCreator().NewInstallationV4Client(installationID)
+}
+
+func (c *reloadingClientCreator) NewTokenSourceClient(ts oauth2.TokenSource) (*github.Client, error) {
+	return c.clientCreator().NewTokenSourceClient(ts)
+}
+
+func (c *reloadingClientCreator) NewTokenSourceV4Client(ts oauth2.TokenSource) (*githubv4.Client, error) {
+	return c.clientCreator().NewTokenSourceV4Client(ts)
+}
+
+func (c *reloadingClientCreator) NewTokenClient(token string) (*github.Client, error) {
+	return c.clientCreator().NewTokenClient(token)
+}
+
+func (c *reloadingClientCreator) NewTokenV4Client(token string) (*githubv4.Client, error) {
+	return c.clientCreator().NewTokenV4Client(token)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitSecrets(t *testing.T) {
+	assert.Equal(t, []string{"new", "old"}, splitSecrets("new\r\n\n old \n"))
+	assert.Nil(t, splitSecrets(""))
+}
+
+func TestValidatePrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	assert.NoError(t, validatePrivateKey(string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))))
+	assert.NoError(t, validatePrivateKey(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))))
+	assert.Error(t, validatePrivateKey("not a key"))
+	assert.Error(t, validatePrivateKey(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("truncated")}))))
+}
+
+func TestChangedSecrets(t *testing.T) {
+	previous := &ReloadableConfig{
+		ReleaseManagerAuthToken: "token",
+		GithubWebhookSecrets:    []string{"secret1"},
+		GithubPrivateKey:        "key",
+		MessageTemplate:         "template1",
+	}
+
+	assert.Empty(t, changedSecrets(previous, &ReloadableConfig{
+		ReleaseManagerAuthToken: "token",
+		GithubWebhookSecrets:    []string{"secret1"},
+		GithubPrivateKey:        "key",
+		MessageTemplate:         "template2",
+	}))
+	assert.Equal(t, []string{"github-webhook-secret", "release-manager-auth-token"}, changedSecrets(previous, &ReloadableConfig{
+		ReleaseManagerAuthToken: "token2",
+		GithubWebhookSecrets:    []string{"secret2", "secret1"},
+		GithubPrivateKey:        "key",
+	}))
+}
+
+func signWebhook(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookSignatureMiddleware(t *testing.T) {
+	body := `{"action":"opened"}`
+	tt := []struct {
+		name      string
+		secrets   []string
+		signature string
+		status    int
+	}{
+		{
+			name:      "current secret",
+			secrets:   []string{"new", "old"},
+			signature: signWebhook("new", body),
+			status:    http.StatusOK,
+		},
+		{
+			name:      "previous secret",
+			secrets:   []string{"new", "old"},
+			signature: signWebhook("old", body),
+			status:    http.StatusOK,
+		},
+		{
+			name:      "unknown secret",
+			secrets:   []string{"new", "old"},
+			signature: signWebhook("other", body),
+			status:    http.StatusUnauthorized,
+		},
+		{
+			name:      "unsigned",
+			secrets:   []string{"new"},
+			signature: "",
+			status:    http.StatusUnauthorized,
+		},
+		{
+			name:      "no secrets",
+			secrets:   nil,
+			signature: "",
+			status:    http.StatusOK,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := webhookSignatureMiddleware(func() []string { return tc.secrets }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Empty(t, r.Header.Get(github.SHA256SignatureHeader), "signature header")
+				received, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, body, string(received), "body")
+			}))
+			r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+			if tc.signature != "" {
+				r.Header.Set(github.SHA256SignatureHeader, tc.signature)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.status, w.Code, "status code")
+		})
+	}
+}
+
+func TestReloadingClientCreator(t *testing.T) {
+	var created []string
+	cc, err := newReloadingClientCreator("key1", func(privateKey string) (githubapp.ClientCreator, error) {
+		created = append(created, privateKey)
+		return githubapp.NewClientCreator("https://api.github.com/", "https://api.github.com/graphql", 1, []byte(privateKey)), nil
+	})
+	require.NoError(t, err)
+	first := cc.clientCreator()
+
+	require.NoError(t, cc.SetPrivateKey("key1"))
+	assert.Same(t, first, cc.clientCreator(), "unchanged key keeps client creator")
+
+	require.NoError(t, cc.SetPrivateKey("key2"))
+	assert.NotSame(t, first, cc.clientCreator(), "changed key recreates client creator")
+	assert.Equal(t, []string{"key1", "key2"}, created)
+}
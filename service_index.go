@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+)
+
+// repositoryRef is a repository and the installation with access to it.
+type repositoryRef struct {
+	installationID int64
+	repository     *github.Repository
+}
+
+// serviceIndex maps service names to the repositories they are built from,
+// i.e. the reverse of getServiceName. It is rebuilt from the repositories of
+// all installations when it is older than maxAge, or when a service is not
+// found and the index was not rebuilt within minRebuildInterval.
+type serviceIndex struct {
+	githubapp.ClientCreator
+
+	serviceName        func(repoName string) string
+	maxAge             time.Duration
+	minRebuildInterval time.Duration
+
+	mu       sync.Mutex
+	services map[string][]repositoryRef
+	builtAt  time.Time
+}
+
+func (index *serviceIndex) repositories(ctx context.Context, service string) ([]repositoryRef, error) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	age := time.Since(index.builtAt)
+	refs, ok := index.services[service]
+	if age < index.maxAge && (ok || age < index.minRebuildInterval) {
+		return refs, nil
+	}
+
+	services, err := index.build(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index.services = services
+	index.builtAt = time.Now()
+
+	return index.services[service], nil
+}
+
+// invalidate makes the next lookup rebuild the index, eg. after the mapping of
+// repositories to services changed.
+func (index *serviceIndex) invalidate() {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.services = nil
+	index.builtAt = time.Time{}
+}
+
+func (index *serviceIndex) build(ctx context.Context) (map[string][]repositoryRef, error) {
+	installationIDs, err := listInstallationIDs(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []repositoryRef
+	for _, installationID := range installationIDs {
+		client, err := index.NewInstallationClient(installationID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating new github.Client from installation id '%d'", installationID)
+		}
+		repositories, err := listRepositories(ctx, client)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing repositories of installation '%d'", installationID)
+		}
+		for _, repository := range repositories {
+			refs = append(refs, repositoryRef{
+				installationID: installationID,
+				repository:     repository,
+			})
+		}
+	}
+
+	return groupByService(refs, index.serviceName), nil
+}
+
+func groupByService(refs []repositoryRef, serviceName func(repoName string) string) map[string][]repositoryRef {
+	services := make(map[string][]repositoryRef)
+	for _, ref := range refs {
+		service := serviceName(ref.repository.GetName())
+		services[service] = append(services[service], ref)
+	}
+	return services
+}
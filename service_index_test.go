@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupByService(t *testing.T) {
+	mapping := map[string]string{"mobile-backend": "api"}
+	refs := []repositoryRef{
+		{installationID: 1, repository: &github.Repository{Name: github.Ptr("lunar-way-payments-service")}},
+		{installationID: 1, repository: &github.Repository{Name: github.Ptr("mobile-backend")}},
+		{installationID: 2, repository: &github.Repository{Name: github.Ptr("payments")}},
+	}
+
+	// Act
+	services := groupByService(refs, func(repoName string) string {
+		return getServiceName(repoName, mapping)
+	})
+
+	// Assert
+	assert.Equal(t, map[string][]repositoryRef{
+		"payments": {refs[0], refs[2]},
+		"api":      {refs[1]},
+	}, services)
+}
+
+func TestServiceIndex_invalidate(t *testing.T) {
+	index := &serviceIndex{
+		maxAge:             time.Hour,
+		minRebuildInterval: time.Minute,
+		services:           map[string][]repositoryRef{"payments": nil},
+		builtAt:            time.Now(),
+	}
+
+	index.invalidate()
+
+	assert.Nil(t, index.services)
+	assert.Greater(t, time.Since(index.builtAt), index.maxAge, "rebuilt on next lookup")
+}
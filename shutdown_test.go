@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerGroup_Wait(t *testing.T) {
+	workers := &workerGroup{}
+	release := make(chan struct{})
+	workers.Go(func() {
+		<-release
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, workers.Wait(ctx), context.DeadlineExceeded)
+
+	close(release)
+	assert.NoError(t, workers.Wait(context.Background()))
+}
+
+func TestWorkerGroup_Go_afterWait(t *testing.T) {
+	workers := &workerGroup{}
+	release := make(chan struct{})
+	defer close(release)
+	assert.True(t, workers.Go(func() {
+		<-release
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, workers.Wait(ctx), context.DeadlineExceeded)
+
+	ran := false
+	assert.False(t, workers.Go(func() {
+		ran = true
+	}), "work is rejected once shutdown started")
+	assert.False(t, ran)
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// simulationAuthToken is the release-manager auth token used in simulations.
+const simulationAuthToken = "simulation"
+
+// fakeWrites records the writes made to a fake service. If path is set every
+// write is appended to it as a JSON line.
+type fakeWrites struct {
+	destination string
+	path        string
+
+	mu     sync.Mutex
+	writes []DryRunWrite
+}
+
+func (f *fakeWrites) record(r *http.Request, body []byte) error {
+	write := DryRunWrite{
+		Time:        time.Now(),
+		Destination: f.destination,
+		Method:      r.Method,
+		URL:         r.URL.String(),
+	}
+	if json.Valid(body) {
+		write.Body = body
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes = append(f.writes, write)
+	if f.path == "" {
+		return nil
+	}
+	line, err := json.Marshal(write)
+	if err != nil {
+		return err
+	}
+	return appendFile(f.path, append(line, '\n'))
+}
+
+// Writes returns the writes made to the fake in the order they were made.
+func (f *fakeWrites) Writes() []DryRunWrite {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]DryRunWrite(nil), f.writes...)
+}
+
+func appendFile(name string, content []byte) error {
+	err := os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readFixture returns the content of a fixture. ok is false if it does not
+// exist.
+func readFixture(fixtures fs.FS, name string) (content []byte, ok bool, err error) {
+	if !fs.ValidPath(name) {
+		return nil, false, nil
+	}
+	content, err = fs.ReadFile(fixtures, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return content, true, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, content []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(content)
+}
+
+// FakeReleaseManager serves release-manager responses from fixtures and records
+// writes. Fixtures are JSON responses named by service:
+//
+//	<service>/artifacts.json  GET /describe/artifact/<service>
+//	<service>/policies.json   GET /policies?service=<service>
+//	<service>/status.json     GET /status?service=<service>
+//	<service>/locks.json      GET /locks?service=<service>
+//
+// Services without a fixture have no artifacts, policies, status or locks.
+type FakeReleaseManager struct {
+	fakeWrites
+	fixtures fs.FS
+}
+
+// NewFakeReleaseManager creates a FakeReleaseManager. Writes are appended to
+// the file 'writes.jsonl' in output unless it is empty.
+func NewFakeReleaseManager(fixtures fs.FS, output string) *FakeReleaseManager {
+	f := &FakeReleaseManager{
+		fakeWrites: fakeWrites{destination: "release-manager"},
+		fixtures:   fixtures,
+	}
+	if output != "" {
+		f.path = filepath.Join(output, "writes.jsonl")
+	}
+	return f
+}
+
+func (f *FakeReleaseManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		body, _ := io.ReadAll(r.Body)
+		err := f.record(r, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, fakePolicyResponse(r, body))
+		return
+	}
+
+	var service, fixture string
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/describe/artifact/"):
+		service, fixture = strings.TrimPrefix(r.URL.Path, "/describe/artifact/"), "artifacts.json"
+	case r.URL.Path == "/policies":
+		service, fixture = r.URL.Query().Get("service"), "policies.json"
+	case r.URL.Path == "/status":
+		service, fixture = r.URL.Query().Get("service"), "status.json"
+	case r.URL.Path == "/locks":
+		service, fixture = r.URL.Query().Get("service"), "locks.json"
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	content, ok, err := readFixture(f.fixtures, path.Join(service, fixture))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		content = []byte(fmt.Sprintf(`{"service":%q}`, service))
+	}
+	writeJSON(w, http.StatusOK, content)
+}
+
+// fakePolicyResponse returns the response of release-manager to applying a
+// policy with the ID release-manager keys it by. Other writes get an empty
+// response.
+func fakePolicyResponse(r *http.Request, body []byte) []byte {
+	var response ApplyPolicyResponse
+	switch r.URL.Path {
+	case "/policies/auto-release":
+		var request ApplyAutoReleasePolicyRequest
+		_ = json.Unmarshal(body, &request)
+		response = ApplyPolicyResponse{ID: fmt.Sprintf("auto-release-%s-%s", request.Branch, request.Environment), Service: request.Service, Branch: request.Branch, Environment: request.Environment}
+	case "/policies/branch-restriction":
+		var request ApplyBranchRestrictionPolicyRequest
+		_ = json.Unmarshal(body, &request)
+		response = ApplyPolicyResponse{ID: fmt.Sprintf("branch-restriction-%s", request.Environment), Service: request.Service, Environment: request.Environment, BranchRegex: request.BranchRegex}
+	default:
+		return []byte("{}")
+	}
+	content, _ := json.Marshal(response)
+	return content
+}
+
+var (
+	fakeGithubAccessTokens = regexp.MustCompile(`^/app/installations/\d+/access_tokens$`)
+	fakeGithubComments     = regexp.MustCompile(`^/repos/([^/]+)/([^/]+)/issues/(\d+)/comments$`)
+	fakeGithubComment      = regexp.MustCompile(`^/repos/([^/]+)/([^/]+)/issues/comments/(\d+)$`)
+	fakeGithubDefaults     = []struct {
+		path *regexp.Regexp
+		body string
+	}{
+		{regexp.MustCompile(`^/app/installations$`), `[{"id":1}]`},
+		{regexp.MustCompile(`^/installation/repositories$`), `{"total_count":0,"repositories":[]}`},
+		{regexp.MustCompile(`^/rate_limit$`), `{"resources":{"core":{"limit":5000,"remaining":5000}}}`},
+		{regexp.MustCompile(`^/repos/[^/]+/[^/]+/pulls$`), `[]`},
+		{regexp.MustCompile(`^/repos/[^/]+/[^/]+/pulls/\d+/files$`), `[]`},
+		{regexp.MustCompile(`^/repos/[^/]+/[^/]+/issues$`), `[]`},
+		{regexp.MustCompile(`^/repos/[^/]+/[^/]+/commits/[^/]+/statuses$`), `[]`},
+		{regexp.MustCompile(`^/repos/[^/]+/[^/]+/commits/[^/]+/check-runs$`), `{"total_count":0,"check_runs":[]}`},
+	}
+)
+
+// FakeGithub serves Github API responses from fixtures and records writes.
+// The fixture of a GET request is the JSON response at its path, eg.
+// 'repos/lunarway/example/pulls/1.json' for GET /repos/lunarway/example/pulls/1.
+// Requests without a fixture get an empty list or 404 Not Found.
+//
+// Comments are kept so the bot finds and updates its own comments. Their
+// bodies are written to '<owner>/<repo>/<number>/comment-<id>.md' in output.
+type FakeGithub struct {
+	fakeWrites
+	fixtures fs.FS
+	output   string
+
+	commentsMu sync.Mutex
+	comments   map[string][]*github.IssueComment
+	commentID  int64
+}
+
+// NewFakeGithub creates a FakeGithub. Writes are appended to the file
+// 'writes.jsonl' in output and comments written to it unless it is empty.
+func NewFakeGithub(fixtures fs.FS, output string) *FakeGithub {
+	f := &FakeGithub{
+		fakeWrites: fakeWrites{destination: "github"},
+		fixtures:   fixtures,
+		output:     output,
+		comments:   make(map[string][]*github.IssueComment),
+	}
+	if output != "" {
+		f.path = filepath.Join(output, "writes.jsonl")
+	}
+	return f
+}
+
+// Comments returns the comments on an issue or pull request.
+func (f *FakeGithub) Comments(owner, repo string, number int) []*github.IssueComment {
+	f.commentsMu.Lock()
+	defer f.commentsMu.Unlock()
+	return append([]*github.IssueComment(nil), f.comments[fmt.Sprintf("%s/%s/%d", owner, repo, number)]...)
+}
+
+func (f *FakeGithub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-RateLimit-Limit", "5000")
+	w.Header().Set("X-RateLimit-Remaining", "5000")
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	urlPath := path.Clean(r.URL.Path)
+	if r.Method == http.MethodGet {
+		f.serveRead(w, r, urlPath)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Installation tokens are part of authentication, not writes
+	if r.Method == http.MethodPost && fakeGithubAccessTokens.MatchString(urlPath) {
+		writeJSON(w, http.StatusCreated, []byte(fmt.Sprintf(`{"token":%q,"expires_at":%q}`, simulationAuthToken, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))))
+		return
+	}
+	err = f.record(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && fakeGithubComments.MatchString(urlPath):
+		match := fakeGithubComments.FindStringSubmatch(urlPath)
+		number, _ := strconv.Atoi(match[3])
+		comment, err := f.saveComment(match[1], match[2], number, 0, body)
+		f.writeComment(w, http.StatusCreated, comment, err)
+	case r.Method == http.MethodPatch && fakeGithubComment.MatchString(urlPath):
+		match := fakeGithubComment.FindStringSubmatch(urlPath)
+		id, _ := strconv.ParseInt(match[3], 10, 64)
+		comment, err := f.saveComment(match[1], match[2], 0, id, body)
+		f.writeComment(w, http.StatusOK, comment, err)
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		// An empty body is accepted by clients regardless of the response type
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (f *FakeGithub) serveRead(w http.ResponseWriter, r *http.Request, urlPath string) {
+	if match := fakeGithubComments.FindStringSubmatch(urlPath); match != nil {
+		number, _ := strconv.Atoi(match[3])
+		content, err := json.Marshal(f.Comments(match[1], match[2], number))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, content)
+		return
+	}
+
+	content, ok, err := readFixture(f.fixtures, strings.TrimPrefix(urlPath, "/")+".json")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ok {
+		writeJSON(w, http.StatusOK, content)
+		return
+	}
+	for _, d := range fakeGithubDefaults {
+		if d.path.MatchString(urlPath) {
+			writeJSON(w, http.StatusOK, []byte(d.body))
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, []byte(`{"message":"Not Found"}`))
+}
+
+// saveComment creates a comment on an issue or, if id is set, updates the
+// comment with id.
+func (f *FakeGithub) saveComment(owner, repo string, number int, id int64, body []byte) (*github.IssueComment, error) {
+	var request github.IssueComment
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing comment")
+	}
+
+	f.commentsMu.Lock()
+	defer f.commentsMu.Unlock()
+	var comment *github.IssueComment
+	if id == 0 {
+		f.commentID++
+		now := github.Timestamp{Time: time.Now()}
+		comment = &github.IssueComment{
+			ID:        github.Ptr(f.commentID),
+			User:      &github.User{Login: github.Ptr("release-manager-bot[bot]"), Type: github.Ptr("Bot")},
+			CreatedAt: &now,
+			IssueURL:  github.Ptr(fmt.Sprintf("/repos/%s/%s/issues/%d", owner, repo, number)),
+		}
+		key := fmt.Sprintf("%s/%s/%d", owner, repo, number)
+		f.comments[key] = append(f.comments[key], comment)
+	} else {
+		for key, comments := range f.comments {
+			if !strings.HasPrefix(key, owner+"/"+repo+"/") {
+				continue
+			}
+			for _, c := range comments {
+				if c.GetID() == id {
+					comment = c
+					number, _ = strconv.Atoi(strings.TrimPrefix(key, owner+"/"+repo+"/"))
+				}
+			}
+		}
+		if comment == nil {
+			return nil, nil
+		}
+	}
+	now := github.Timestamp{Time: time.Now()}
+	comment.Body = request.Body
+	comment.UpdatedAt = &now
+
+	if f.output == "" {
+		return comment, nil
+	}
+	name := filepath.Join(f.output, owner, repo, strconv.Itoa(number), fmt.Sprintf("comment-%d.md", comment.GetID()))
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return comment, os.WriteFile(name, []byte(comment.GetBody()), 0o644)
+}
+
+func (f *FakeGithub) writeComment(w http.ResponseWriter, status int, comment *github.IssueComment, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if comment == nil {
+		writeJSON(w, http.StatusNotFound, []byte(`{"message":"Not Found"}`))
+		return
+	}
+	content, err := json.Marshal(comment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status, content)
+}
+
+// generatePrivateKey returns a PEM encoded private key for a simulated Github
+// app.
+func generatePrivateKey() (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})), nil
+}
+
+// simulation runs a FakeGithub and a FakeReleaseManager on local servers with
+// the fixtures of a directory:
+//
+//	<dir>/github/                     FakeGithub fixtures
+//	<dir>/release-manager/            FakeReleaseManager fixtures
+//	<dir>/output/<started>-<random>/  writes and comments of a run
+//
+// Every run writes to a new output directory so earlier output is kept.
+type simulation struct {
+	Github         *FakeGithub
+	ReleaseManager *FakeReleaseManager
+	Output         string
+
+	githubServer         *httptest.Server
+	releaseManagerServer *httptest.Server
+	privateKey           string
+}
+
+func startSimulation(dir string) (*simulation, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("'%s' is not a directory", dir)
+	}
+	privateKey, err := generatePrivateKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "generating private key")
+	}
+
+	err = os.MkdirAll(filepath.Join(dir, "output"), 0o755)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating output directory")
+	}
+	output, err := os.MkdirTemp(filepath.Join(dir, "output"), time.Now().UTC().Format("20060102T150405Z")+"-")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating output directory of run")
+	}
+	s := &simulation{
+		Github:         NewFakeGithub(os.DirFS(filepath.Join(dir, "github")), filepath.Join(output, "github")),
+		ReleaseManager: NewFakeReleaseManager(os.DirFS(filepath.Join(dir, "release-manager")), filepath.Join(output, "release-manager")),
+		Output:         output,
+		privateKey:     privateKey,
+	}
+	s.githubServer = httptest.NewServer(s.Github)
+	s.releaseManagerServer = httptest.NewServer(s.ReleaseManager)
+	return s, nil
+}
+
+// configure points the Github and release-manager flags at the fakes.
+func (s *simulation) configure(flags *pflag.FlagSet) error {
+	values := map[string]string{
+		"github-v3-api-url":          s.githubServer.URL + "/",
+		"github-private-key":         s.privateKey,
+		"release-manager-url":        s.releaseManagerServer.URL,
+		"release-manager-auth-token": simulationAuthToken,
+	}
+	for name, value := range values {
+		err := flags.Set(name, value)
+		if err != nil {
+			return errors.Wrapf(err, "setting '%s'", name)
+		}
+	}
+	return nil
+}
+
+func (s *simulation) Close() {
+	s.githubServer.Close()
+	s.releaseManagerServer.Close()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeRequest(t *testing.T, handler http.Handler, method, target, body string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	content, err := io.ReadAll(w.Result().Body)
+	require.NoError(t, err)
+	return w.Code, string(content)
+}
+
+func TestFakeReleaseManager(t *testing.T) {
+	output := t.TempDir()
+	fake := NewFakeReleaseManager(fstest.MapFS{
+		"example/artifacts.json": {Data: []byte(`{"service":"example","artifacts":[{"id":"master-abc"}]}`)},
+		"example/policies.json":  {Data: []byte(`{"service":"example","autoReleases":[{"branch":"master","environment":"dev"}]}`)},
+	}, output)
+
+	tt := []struct {
+		name   string
+		target string
+		body   string
+	}{
+		{name: "artifacts", target: "/describe/artifact/example?count=50", body: `{"service":"example","artifacts":[{"id":"master-abc"}]}`},
+		{name: "policies", target: "/policies?service=example", body: `{"service":"example","autoReleases":[{"branch":"master","environment":"dev"}]}`},
+		{name: "missing status", target: "/status?service=example", body: `{"service":"example"}`},
+		{name: "unknown service", target: "/describe/artifact/other?count=50", body: `{"service":"other"}`},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := fakeRequest(t, fake, http.MethodGet, tc.target, "")
+			assert.Equal(t, http.StatusOK, status)
+			assert.JSONEq(t, tc.body, body)
+		})
+	}
+
+	status, _ := fakeRequest(t, fake, http.MethodPost, "/holds", `{"service":"example"}`)
+	assert.Equal(t, http.StatusOK, status)
+	writes := fake.Writes()
+	require.Len(t, writes, 1)
+	assert.Equal(t, "/holds", writes[0].URL)
+	assert.JSONEq(t, `{"service":"example"}`, string(writes[0].Body))
+	content, err := os.ReadFile(filepath.Join(output, "writes.jsonl"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"url":"/holds"`)
+}
+
+func TestFakeGithub(t *testing.T) {
+	output := t.TempDir()
+	fake := NewFakeGithub(fstest.MapFS{
+		"repos/lunarway/example/pulls/1.json": {Data: []byte(`{"number":1}`)},
+	}, output)
+
+	status, body := fakeRequest(t, fake, http.MethodGet, "/repos/lunarway/example/pulls/1", "")
+	assert.Equal(t, http.StatusOK, status, "fixture")
+	assert.JSONEq(t, `{"number":1}`, body)
+
+	status, body = fakeRequest(t, fake, http.MethodGet, "/repos/lunarway/example/pulls?state=open", "")
+	assert.Equal(t, http.StatusOK, status, "default list")
+	assert.JSONEq(t, `[]`, body)
+
+	status, _ = fakeRequest(t, fake, http.MethodGet, "/repos/lunarway/example/pulls/2", "")
+	assert.Equal(t, http.StatusNotFound, status, "missing fixture")
+
+	status, _ = fakeRequest(t, fake, http.MethodGet, "/repos/lunarway/example/pulls/../../../../../etc/passwd", "")
+	assert.Equal(t, http.StatusNotFound, status, "outside fixtures")
+
+	// Comments
+	status, _ = fakeRequest(t, fake, http.MethodPost, "/repos/lunarway/example/issues/1/comments", `{"body":"first"}`)
+	assert.Equal(t, http.StatusCreated, status, "create comment")
+	status, _ = fakeRequest(t, fake, http.MethodPatch, "/repos/lunarway/example/issues/comments/1", `{"body":"updated"}`)
+	assert.Equal(t, http.StatusOK, status, "update comment")
+	status, _ = fakeRequest(t, fake, http.MethodPatch, "/repos/lunarway/example/issues/comments/2", `{"body":"unknown"}`)
+	assert.Equal(t, http.StatusNotFound, status, "update unknown comment")
+	status, body = fakeRequest(t, fake, http.MethodGet, "/repos/lunarway/example/issues/1/comments", "")
+	assert.Equal(t, http.StatusOK, status, "list comments")
+	assert.Contains(t, body, `"body":"updated"`)
+
+	comments := fake.Comments("lunarway", "example", 1)
+	require.Len(t, comments, 1)
+	assert.Equal(t, "updated", comments[0].GetBody())
+	content, err := os.ReadFile(filepath.Join(output, "lunarway", "example", "1", "comment-1.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "updated", string(content))
+
+	// Other writes
+	status, body = fakeRequest(t, fake, http.MethodPost, "/repos/lunarway/example/issues/1/labels", `["auto-release:dev"]`)
+	assert.Equal(t, http.StatusOK, status, "add labels")
+	assert.Empty(t, body)
+	status, _ = fakeRequest(t, fake, http.MethodPost, "/app/installations/1/access_tokens", "")
+	assert.Equal(t, http.StatusCreated, status, "access token")
+
+	var methods []string
+	for _, write := range fake.Writes() {
+		methods = append(methods, write.Method+" "+write.URL)
+	}
+	assert.Equal(t, []string{
+		"POST /repos/lunarway/example/issues/1/comments",
+		"PATCH /repos/lunarway/example/issues/comments/1",
+		"PATCH /repos/lunarway/example/issues/comments/2",
+		"POST /repos/lunarway/example/issues/1/labels",
+	}, methods)
+}
+
+func TestStartSimulation(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "output"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "output", "stale.md"), nil, 0o644))
+
+	sim, err := startSimulation(dir)
+	require.NoError(t, err)
+	defer sim.Close()
+	assert.FileExists(t, filepath.Join(dir, "output", "stale.md"), "earlier output kept")
+	assert.Equal(t, filepath.Join(dir, "output"), filepath.Dir(sim.Output))
+	assert.DirExists(t, sim.Output)
+
+	flags := reloadTestFlags()
+	flags.String("github-v3-api-url", "https://api.github.com/", "")
+	flags.String("release-manager-url", "http://localhost:8080", "")
+	require.NoError(t, sim.configure(flags))
+
+	config, err := reloadableConfigFromFlags(flags, os.ReadFile)
+	require.NoError(t, err)
+	assert.Equal(t, simulationAuthToken, config.ReleaseManagerAuthToken)
+	assert.NotEmpty(t, config.GithubPrivateKey)
+	assert.Equal(t, sim.releaseManagerServer.URL, flags.Lookup("release-manager-url").Value.String())
+
+	_, err = startSimulation(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,24 @@
+package main
+
+// sensitiveAutoReleases returns the auto-release environments that are
+// configured as sensitive, e.g. prod.
+func sensitiveAutoReleases(autoReleaseEnvironments, sensitiveEnvironments []string) []string {
+	var sensitive []string
+	for _, environment := range autoReleaseEnvironments {
+		if any(sensitiveEnvironments, func(sensitiveEnvironment string) bool {
+			return sensitiveEnvironment == environment
+		}) {
+			sensitive = append(sensitive, environment)
+		}
+	}
+	return sensitive
+}
+
+// squadTeam returns the slug of the GitHub team owning squad or an empty
+// string if the squad is not mapped to a team.
+func squadTeam(squad string, mapping map[string]string) string {
+	if squad == "" {
+		return ""
+	}
+	return mapping[squad]
+}
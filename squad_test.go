@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSensitiveAutoReleases(t *testing.T) {
+	tt := []struct {
+		name                    string
+		autoReleaseEnvironments []string
+		sensitiveEnvironments   []string
+		expected                []string
+	}{
+		{
+			name:                    "prod auto-release",
+			autoReleaseEnvironments: []string{"dev", "prod"},
+			sensitiveEnvironments:   []string{"prod"},
+			expected:                []string{"prod"},
+		},
+		{
+			name:                    "no sensitive auto-release",
+			autoReleaseEnvironments: []string{"dev", "staging"},
+			sensitiveEnvironments:   []string{"prod"},
+			expected:                nil,
+		},
+		{
+			name:                    "no sensitive environments configured",
+			autoReleaseEnvironments: []string{"dev", "prod"},
+			sensitiveEnvironments:   nil,
+			expected:                nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, sensitiveAutoReleases(tc.autoReleaseEnvironments, tc.sensitiveEnvironments))
+		})
+	}
+}
+
+func TestSquadTeam(t *testing.T) {
+	mapping := map[string]string{"aura": "squad-aura"}
+
+	assert.Equal(t, "squad-aura", squadTeam("aura", mapping), "mapped squad")
+	assert.Equal(t, "", squadTeam("nasa", mapping), "unmapped squad")
+	assert.Equal(t, "", squadTeam("aura", nil), "no mapping")
+	assert.Equal(t, "", squadTeam("", mapping), "no squad")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// Stage IDs used by release-manager artifact pipelines.
+const (
+	stageBuild      = "build"
+	stageTest       = "test"
+	stagePush       = "push"
+	stageSnykCode   = "snyk-code"
+	stageSnykDocker = "snyk-docker"
+)
+
+type BuildData struct {
+	Image         string `json:"image,omitempty"`
+	Tag           string `json:"tag,omitempty"`
+	DockerVersion string `json:"dockerVersion,omitempty"`
+}
+
+type TestData struct {
+	URL     string     `json:"url,omitempty"`
+	Results TestResult `json:"results,omitempty"`
+}
+
+type TestResult struct {
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+type PushData struct {
+	Image string `json:"image,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+type SnykCodeData struct {
+	Language        string              `json:"language,omitempty"`
+	SnykVersion     string              `json:"snykVersion,omitempty"`
+	URL             string              `json:"url,omitempty"`
+	Vulnerabilities VulnerabilityResult `json:"vulnerabilities,omitempty"`
+}
+
+type SnykDockerData struct {
+	BaseImage       string              `json:"baseImage,omitempty"`
+	Tag             string              `json:"tag,omitempty"`
+	SnykVersion     string              `json:"snykVersion,omitempty"`
+	URL             string              `json:"url,omitempty"`
+	Vulnerabilities VulnerabilityResult `json:"vulnerabilities,omitempty"`
+}
+
+type VulnerabilityResult struct {
+	High   int `json:"high"`
+	Medium int `json:"medium"`
+	Low    int `json:"low"`
+}
+
+// ArtifactStages holds the decoded pipeline stages of an artifact. Stages that
+// are not present are nil. Stages of unknown kinds, or known stages that
+// cannot be decoded, are kept as raw JSON in Unknown keyed by stage ID.
+type ArtifactStages struct {
+	Build      *BuildData
+	Test       *TestData
+	Push       *PushData
+	SnykCode   *SnykCodeData
+	SnykDocker *SnykDockerData
+	Unknown    map[string]json.RawMessage
+}
+
+func decodeStages(stages []Stage) ArtifactStages {
+	var decoded ArtifactStages
+	for _, stage := range stages {
+		ok := false
+		switch stage.ID {
+		case stageBuild:
+			decoded.Build, ok = decodeStage[BuildData](stage.Data)
+		case stageTest:
+			decoded.Test, ok = decodeStage[TestData](stage.Data)
+		case stagePush:
+			decoded.Push, ok = decodeStage[PushData](stage.Data)
+		case stageSnykCode:
+			decoded.SnykCode, ok = decodeStage[SnykCodeData](stage.Data)
+		case stageSnykDocker:
+			decoded.SnykDocker, ok = decodeStage[SnykDockerData](stage.Data)
+		}
+		if !ok {
+			if decoded.Unknown == nil {
+				decoded.Unknown = make(map[string]json.RawMessage)
+			}
+			decoded.Unknown[stage.ID] = stage.Data
+		}
+	}
+	return decoded
+}
+
+func decodeStage[T interface{}](data json.RawMessage) (*T, bool) {
+	var stage T
+	if err := json.Unmarshal(data, &stage); err != nil {
+		return nil, false
+	}
+	return &stage, true
+}
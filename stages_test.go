@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeStages(t *testing.T) {
+	stages := []Stage{
+		{ID: "build", Name: "Build", Data: json.RawMessage(`{"image":"quay.io/lunarway/example","tag":"master-1234567890-42"}`)},
+		{ID: "test", Name: "Test", Data: json.RawMessage(`{"url":"https://jenkins/job/42","results":{"passed":412,"failed":0,"skipped":3}}`)},
+		{ID: "snyk-docker", Name: "Security Scan - Docker", Data: json.RawMessage(`{"vulnerabilities":{"high":2,"medium":1,"low":0}}`)},
+		{ID: "push", Name: "Push", Data: json.RawMessage(`"not an object"`)},
+		{ID: "lint", Name: "Lint", Data: json.RawMessage(`{"warnings":7}`)},
+	}
+
+	// Act
+	decoded := decodeStages(stages)
+
+	// Assert
+	assert.Equal(t, &BuildData{Image: "quay.io/lunarway/example", Tag: "master-1234567890-42"}, decoded.Build)
+	assert.Equal(t, &TestData{URL: "https://jenkins/job/42", Results: TestResult{Passed: 412, Failed: 0, Skipped: 3}}, decoded.Test)
+	assert.Equal(t, &SnykDockerData{Vulnerabilities: VulnerabilityResult{High: 2, Medium: 1}}, decoded.SnykDocker)
+	assert.Nil(t, decoded.Push, "undecodable known stage")
+	assert.Nil(t, decoded.SnykCode, "missing stage")
+	assert.Equal(t, map[string]json.RawMessage{
+		"push": json.RawMessage(`"not an object"`),
+		"lint": json.RawMessage(`{"warnings":7}`),
+	}, decoded.Unknown)
+}
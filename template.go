@@ -10,7 +10,15 @@ import (
 type BotMessageData struct {
 	Template                string
 	Branch                  string
+	HeadSHA                 string
 	AutoReleaseEnvironments []string
+	Releases                []EnvironmentRelease
+	ArtifactID              string
+	Stages                  ArtifactStages
+	Squad                   string
+	SquadTeam               string
+	SensitiveEnvironments   []string
+	OutsideDeploymentWindow []string
 }
 
 func BotMessage(data BotMessageData) (string, error) {
@@ -22,6 +30,7 @@ func BotMessage(data BotMessageData) (string, error) {
 
 	templateFuncs := template.FuncMap{
 		"contains":   strings.Contains,
+		"join":       strings.Join,
 		"replaceAll": strings.ReplaceAll,
 	}
 
@@ -38,3 +47,31 @@ func BotMessage(data BotMessageData) (string, error) {
 
 	return message.String(), nil
 }
+
+// validateMessageTemplate renders messageTemplate with sample data using all
+// fields so errors surface before a pull request is commented.
+func validateMessageTemplate(messageTemplate string) error {
+	_, err := BotMessage(BotMessageData{
+		Template:                messageTemplate,
+		Branch:                  "master",
+		HeadSHA:                 "0123456789abcdef0123456789abcdef01234567",
+		AutoReleaseEnvironments: []string{"dev", "prod"},
+		Releases: []EnvironmentRelease{
+			{Environment: "dev"},
+			{Environment: "prod", DeployedArtifactID: "master-0123456789-1", DeployedSHA: "0123456789", CommitCount: 5, CompareURL: "https://github.com"},
+		},
+		ArtifactID:              "master-0123456789-2",
+		Squad:                   "squad",
+		SquadTeam:               "@lunarway/squad",
+		SensitiveEnvironments:   []string{"prod"},
+		OutsideDeploymentWindow: []string{"prod"},
+		Stages: ArtifactStages{
+			Build:      &BuildData{},
+			Test:       &TestData{},
+			Push:       &PushData{},
+			SnykCode:   &SnykCodeData{},
+			SnykDocker: &SnykDockerData{},
+		},
+	})
+	return err
+}
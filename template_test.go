@@ -25,6 +25,40 @@ func TestBotMessage(t *testing.T) {
 			expectedMessage: "'master' will auto-release to: \n dev",
 			expectedError:   false,
 		},
+		{
+			name: "releases compared with deployed",
+			input: BotMessageData{
+				Template: "'{{.Branch}}' will auto-release to: {{range .Releases}}\n {{.Environment}}{{if .CompareURL}} ([{{.CommitCount}} commits]({{.CompareURL}}) since {{.DeployedArtifactID}}){{end}}{{end}}",
+				Branch:   "master",
+				Releases: []EnvironmentRelease{
+					{
+						Environment: "dev",
+					},
+					{
+						Environment:        "prod",
+						DeployedArtifactID: "master-1234567890-42",
+						DeployedSHA:        "1234567890",
+						CommitCount:        6,
+						CompareURL:         "https://github.com/lunarway/example/compare/1234567890...abcdef",
+					},
+				},
+			},
+			expectedMessage: "'master' will auto-release to: \n dev\n prod ([6 commits](https://github.com/lunarway/example/compare/1234567890...abcdef) since master-1234567890-42)",
+			expectedError:   false,
+		},
+		{
+			name: "artifact stages",
+			input: BotMessageData{
+				Template: "{{with .Stages.Test}}tests: {{.Results.Passed}} passed, {{.Results.Failed}} failed{{end}}{{with .Stages.SnykDocker}}; snyk: {{.Vulnerabilities.High}} high{{end}}{{with .Stages.SnykCode}}; snyk code: {{.Vulnerabilities.High}} high{{end}}",
+				Branch:   "master",
+				Stages: ArtifactStages{
+					Test:       &TestData{Results: TestResult{Passed: 412}},
+					SnykDocker: &SnykDockerData{Vulnerabilities: VulnerabilityResult{High: 2}},
+				},
+			},
+			expectedMessage: "tests: 412 passed, 0 failed; snyk: 2 high",
+			expectedError:   false,
+		},
 		{
 			name: "invalid template",
 			input: BotMessageData{
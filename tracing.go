@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of spans created by the bot.
+const tracerName = "github.com/lunarway/release-manager-bot"
+
+// Tracing exporters
+const (
+	TracingExporterNone   = "none"
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+)
+
+// setupTracing registers a global tracer provider exporting spans with
+// exporter. OTLP is exported over HTTP to otlpEndpoint or, if empty, the
+// endpoint of the standard OTEL_EXPORTER_OTLP_* environment variables. The
+// returned function flushes and stops the exporter.
+func setupTracing(ctx context.Context, exporter, otlpEndpoint string, sampleRatio float64) (func(ctx context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case TracingExporterNone, "":
+		return func(ctx context.Context) error { return nil }, nil
+	case TracingExporterOTLP:
+		var opts []otlptracehttp.Option
+		if otlpEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(otlpEndpoint))
+		}
+		spanExporter, err = otlptracehttp.New(ctx, opts...)
+	case TracingExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	default:
+		return nil, errors.Errorf("unknown exporter '%s'; expected one of '%s', '%s' or '%s'", exporter, TracingExporterNone, TracingExporterOTLP, TracingExporterStdout)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating %s exporter", exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("release-manager-bot")))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating resource")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// tracingEventHandler starts a root span for every delivery handled by an
+// event handler.
+type tracingEventHandler struct {
+	githubapp.EventHandler
+	tracer trace.Tracer
+}
+
+func (h *tracingEventHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	attributes := []attribute.KeyValue{
+		attribute.String("github.delivery_id", deliveryID),
+		attribute.String("github.event", eventType),
+	}
+	var p deliveryPayload
+	if json.Unmarshal(payload, &p) == nil {
+		pullRequest := p.Number
+		if pullRequest == 0 {
+			pullRequest = p.Issue.Number
+		}
+		attributes = append(attributes,
+			attribute.String("github.action", p.Action),
+			attribute.String("github.repository", p.Repository.FullName),
+			attribute.Int("github.pull_request", pullRequest),
+		)
+	}
+
+	ctx, span := h.tracer.Start(ctx, "webhook "+eventType, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attributes...))
+	defer span.End()
+
+	err := h.EventHandler.Handle(ctx, eventType, deliveryID, payload)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// traceFilterCheck runs check of filter in a span of the delivery traced with
+// ctx and reports whether the filter triggered.
+func traceFilterCheck(ctx context.Context, filter string, check func() bool) bool {
+	_, span := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(ctx, "filter "+filter, trace.WithAttributes(
+		attribute.String("filter.name", filter),
+	))
+	defer span.End()
+	triggered := check()
+	span.SetAttributes(attribute.Bool("filter.triggered", triggered))
+	return triggered
+}
+
+// tracingMiddleware creates a client span for outbound requests to destination
+// and propagates the trace context to it.
+func tracingMiddleware(tracer trace.Tracer, destination string) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			ctx, span := tracer.Start(r.Context(), destination+" "+r.Method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+				attribute.String("peer.service", destination),
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLFull(r.URL.Redacted()),
+				semconv.ServerAddress(r.URL.Hostname()),
+			))
+			defer span.End()
+
+			r = r.Clone(ctx)
+			otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+			res, err := next.RoundTrip(r)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return res, err
+			}
+			span.SetAttributes(semconv.HTTPResponseStatusCode(res.StatusCode))
+			if res.StatusCode >= 400 {
+				span.SetStatus(codes.Error, res.Status)
+			}
+			return res, err
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-github/v69/github"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingEventHandler(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	releaseManager := &http.Client{Transport: tracingMiddleware(tracer, "release-manager")(http.DefaultTransport)}
+
+	handler := &tracingEventHandler{
+		EventHandler: fakeEventHandler(func(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/policies?service=product", nil)
+			require.NoError(t, err)
+			res, err := releaseManager.Do(req)
+			require.NoError(t, err)
+			res.Body.Close()
+			checkFilter(ctx, "UnmanagedService", func() bool { return true })
+			return errors.New("release-manager unavailable")
+		}),
+		tracer: tracer,
+	}
+
+	err := handler.Handle(context.Background(), "pull_request", "delivery-id", []byte(`{"action": "opened", "number": 42, "repository": {"full_name": "lunarway/lunar-way-product-service"}}`))
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	request, filter, root := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "webhook pull_request", root.Name())
+	assert.Equal(t, codes.Error, root.Status().Code)
+	assert.Subset(t, root.Attributes(), []attribute.KeyValue{
+		attribute.String("github.delivery_id", "delivery-id"),
+		attribute.String("github.action", "opened"),
+		attribute.String("github.repository", "lunarway/lunar-way-product-service"),
+		attribute.Int("github.pull_request", 42),
+	})
+
+	assert.Equal(t, "release-manager GET", request.Name())
+	assert.Equal(t, root.SpanContext().SpanID(), request.Parent().SpanID())
+	assert.Contains(t, request.Attributes(), attribute.Int("http.response.status_code", http.StatusNotFound))
+	assert.Equal(t, codes.Error, request.Status().Code)
+
+	assert.Equal(t, "filter UnmanagedService", filter.Name())
+	assert.Equal(t, root.SpanContext().SpanID(), filter.Parent().SpanID())
+}
+
+func TestSetupTracing_unknownExporter(t *testing.T) {
+	_, err := setupTracing(context.Background(), "zipkin", "", 1)
+	assert.Error(t, err)
+}
+
+func TestPRCreateHandler_filterSpans(t *testing.T) {
+	pullRequest, err := replayOptions{
+		Synthetic:      SyntheticEventOpened,
+		Repository:     "lunarway/example",
+		PullRequest:    1,
+		Base:           "master",
+		Head:           "feature",
+		HeadSHA:        "abc",
+		InstallationID: 1,
+	}.syntheticPullRequestEvent()
+	require.NoError(t, err)
+	repository := &github.Repository{
+		Name:  github.Ptr("example"),
+		Owner: &github.User{Login: github.Ptr("lunarway")},
+	}
+	issueComment, err := json.Marshal(github.IssueCommentEvent{
+		Action:  github.Ptr("created"),
+		Issue:   &github.Issue{Number: github.Ptr(1), PullRequestLinks: &github.PullRequestLinks{}},
+		Comment: &github.IssueComment{Body: github.Ptr("LGTM")},
+		Repo:    repository,
+	})
+	require.NoError(t, err)
+	checkRun, err := json.Marshal(github.CheckRunEvent{
+		Action:   github.Ptr("completed"),
+		CheckRun: &github.CheckRun{Name: github.Ptr("release-manager/artifact")},
+		Repo:     repository,
+	})
+	require.NoError(t, err)
+
+	tt := []struct {
+		name      string
+		eventType string
+		payload   []byte
+		expected  map[string]bool
+	}{
+		{
+			name:      "pull request",
+			eventType: "pull_request",
+			payload:   pullRequest,
+			expected: map[string]bool{
+				"filter ActionType":       false,
+				"filter UnmanagedService": true,
+			},
+		},
+		{
+			name:      "issue comment",
+			eventType: "issue_comment",
+			payload:   issueComment,
+			expected: map[string]bool{
+				"filter ExplainDisabled": false,
+				"filter ActionType":      false,
+				"filter NotPullRequest":  false,
+				"filter NotCommand":      true,
+			},
+		},
+		{
+			name:      "check run",
+			eventType: "check_run",
+			payload:   checkRun,
+			expected: map[string]bool{
+				"filter ReleaseReadinessDisabled": false,
+				"filter ActionType":               true,
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+			handler, _, _ := newFakeHandler(t, fstest.MapFS{}, fstest.MapFS{})
+			handler.releaseReadinessCheck = true
+			handler.releaseReadinessCheckName = "release-manager/artifact"
+			ctx, root := tracer.Start(zerolog.Nop().WithContext(context.Background()), "webhook "+tc.eventType)
+
+			err := handler.Handle(ctx, tc.eventType, "delivery-id", tc.payload)
+			root.End()
+
+			require.NoError(t, err)
+			filters := map[string]bool{}
+			for _, span := range recorder.Ended() {
+				if !strings.HasPrefix(span.Name(), "filter ") {
+					continue
+				}
+				assert.Equal(t, root.SpanContext().SpanID(), span.Parent().SpanID())
+				for _, attr := range span.Attributes() {
+					if attr.Key == "filter.triggered" {
+						filters[span.Name()] = attr.Value.AsBool()
+					}
+				}
+			}
+			assert.Equal(t, tc.expected, filters)
+		})
+	}
+}
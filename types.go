@@ -1,6 +1,9 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Would be nice to be able to do, instead of this:
 // httpinternal "github.com/lunarway/release-manager/internal/http"
@@ -13,17 +16,53 @@ type ListPoliciesResponse struct {
 }
 
 type AutoReleasePolicy struct {
-	ID          string `json:"id,omitempty"`
-	Branch      string `json:"branch,omitempty"`
-	Environment string `json:"environment,omitempty"`
+	ID          string `json:"id,omitempty" yaml:"-"`
+	Branch      string `json:"branch,omitempty" yaml:"branch"`
+	Environment string `json:"environment,omitempty" yaml:"environment"`
 }
 
 type BranchRestrictionPolicy struct {
+	ID          string `json:"id,omitempty" yaml:"-"`
+	Environment string `json:"environment,omitempty" yaml:"environment"`
+	BranchRegex string `json:"branchRegex,omitempty" yaml:"branchRegex"`
+}
+
+type ApplyAutoReleasePolicyRequest struct {
+	Service        string `json:"service,omitempty"`
+	Branch         string `json:"branch,omitempty"`
+	Environment    string `json:"environment,omitempty"`
+	CommitterName  string `json:"committerName,omitempty"`
+	CommitterEmail string `json:"committerEmail,omitempty"`
+}
+
+type ApplyBranchRestrictionPolicyRequest struct {
+	Service        string `json:"service,omitempty"`
+	Environment    string `json:"environment,omitempty"`
+	BranchRegex    string `json:"branchRegex,omitempty"`
+	CommitterName  string `json:"committerName,omitempty"`
+	CommitterEmail string `json:"committerEmail,omitempty"`
+}
+
+type ApplyPolicyResponse struct {
 	ID          string `json:"id,omitempty"`
+	Service     string `json:"service,omitempty"`
+	Branch      string `json:"branch,omitempty"`
 	Environment string `json:"environment,omitempty"`
 	BranchRegex string `json:"branchRegex,omitempty"`
 }
 
+type DeletePolicyRequest struct {
+	Service        string   `json:"service,omitempty"`
+	PolicyIDs      []string `json:"policyIds,omitempty"`
+	CommitterName  string   `json:"committerName,omitempty"`
+	CommitterEmail string   `json:"committerEmail,omitempty"`
+}
+
+type DeletePolicyResponse struct {
+	Service string `json:"service,omitempty"`
+	Count   int    `json:"count,omitempty"`
+}
+
 // describeArtifact
 type DescribeArtifactResponse struct {
 	Service   string `json:"service,omitempty"`
@@ -66,7 +105,79 @@ type CI struct {
 }
 
 type Stage struct {
-	ID   string      `json:"id,omitempty"`
-	Name string      `json:"name,omitempty"`
-	Data interface{} `json:"data,omitempty"`
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// status
+type StatusResponse struct {
+	DefaultNamespaces bool          `json:"defaultNamespaces,omitempty"`
+	Environments      []Environment `json:"environments,omitempty"`
+}
+
+type Environment struct {
+	Name                  string `json:"name,omitempty"`
+	Tag                   string `json:"tag,omitempty"`
+	Committer             string `json:"committer,omitempty"`
+	Author                string `json:"author,omitempty"`
+	Message               string `json:"message,omitempty"`
+	Date                  int64  `json:"date,omitempty"`
+	BuildURL              string `json:"buildUrl,omitempty"`
+	HighVulnerabilities   int64  `json:"highVulnerabilities,omitempty"`
+	MediumVulnerabilities int64  `json:"mediumVulnerabilities,omitempty"`
+	LowVulnerabilities    int64  `json:"lowVulnerabilities,omitempty"`
+}
+
+// hold
+type ReleaseHoldRequest struct {
+	ID          string `json:"id,omitempty"`
+	Service     string `json:"service,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	CreatedBy   string `json:"createdBy,omitempty"`
+}
+
+type ReleaseHoldResponse struct {
+	ID          string `json:"id,omitempty"`
+	Service     string `json:"service,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	CreatedBy   string `json:"createdBy,omitempty"`
+}
+
+type DeleteReleaseHoldRequest struct {
+	ID          string `json:"id,omitempty"`
+	Service     string `json:"service,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+}
+
+// locks
+type ListLocksResponse struct {
+	Service string            `json:"service,omitempty"`
+	Locks   []EnvironmentLock `json:"locks,omitempty"`
+}
+
+type EnvironmentLock struct {
+	Environment string    `json:"environment,omitempty"`
+	Kind        string    `json:"kind,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	LockedBy    string    `json:"lockedBy,omitempty"`
+	LockedAt    time.Time `json:"lockedAt,omitempty"`
+	Until       time.Time `json:"until,omitempty"`
+}
+
+// events
+const (
+	EventTypePolicyChanged = "policy-changed"
+	EventTypeLockChanged   = "lock-changed"
+)
+
+type ReleaseManagerEvent struct {
+	Type        string `json:"type,omitempty"`
+	Service     string `json:"service,omitempty"`
+	Environment string `json:"environment,omitempty"`
 }